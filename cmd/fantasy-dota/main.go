@@ -1,6 +1,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/labstack/echo/v4"
 
 	application "github.com/redrru/fantasy-dota/internal/fantasy-dota"
@@ -12,10 +18,15 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+
 	app := application.NewApplication()
 
 	repo := repository.NewRepository(app.DB)
-	uc := usecase.NewUsecase(repo)
+	uc := usecase.NewUsecase(repo, app.Fetcher())
 
 	e := echo.New()
 	server.RegisterHandlers(e, http.NewServer(uc))
@@ -26,3 +37,15 @@ func main() {
 
 	app.Run()
 }
+
+// migrate runs the migration subcommand without starting the application.
+func migrate(args []string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := application.Migrate(ctx, args, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		stop()
+		os.Exit(1)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -17,9 +18,11 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
 	"go.uber.org/zap"
 
+	"github.com/redrru/fantasy-dota/internal/fantasy-dota/migrations"
 	postgres "github.com/redrru/fantasy-dota/pkg/db"
 	"github.com/redrru/fantasy-dota/pkg/env"
 	httpfetcher "github.com/redrru/fantasy-dota/pkg/fetcher"
+	httpclient "github.com/redrru/fantasy-dota/pkg/http"
 	"github.com/redrru/fantasy-dota/pkg/log"
 	"github.com/redrru/fantasy-dota/pkg/middleware"
 )
@@ -27,7 +30,8 @@ import (
 const (
 	appVersionEnv = "APP_VERSION"
 
-	httpPortEnv = "APP_HTTP_PORT"
+	httpPortEnv   = "APP_HTTP_PORT"
+	adminTokenEnv = "APP_ADMIN_TOKEN"
 
 	jaegerHostEnv = "JAEGER_AGENT_HOST"
 	jaegerPortEnv = "JAEGER_AGENT_PORT"
@@ -37,6 +41,53 @@ const (
 	postgresMaxOpenConns    = "PG_MAX_OPEN_CONNS"
 	postgresConnMaxLifetime = "PG_CONN_MAX_LIFETIME"
 	postgresConnMaxIdleTime = "PG_CONN_MAX_IDLE_TIME"
+	postgresMigrationMode   = "PG_MIGRATION_MODE"
+
+	postgresReplicaDSNs           = "PG_REPLICA_DSNS"
+	postgresReplicaHealthInterval = "PG_REPLICA_HEALTH_INTERVAL"
+
+	httpRateLimits            = "HTTP_RATE_LIMITS"
+	httpAuth                  = "HTTP_AUTH"
+	httpDialTimeout           = "HTTP_DIAL_TIMEOUT"
+	httpTLSHandshakeTimeout   = "HTTP_TLS_HANDSHAKE_TIMEOUT"
+	httpResponseHeaderTimeout = "HTTP_RESPONSE_HEADER_TIMEOUT"
+	httpIdleConnTimeout       = "HTTP_IDLE_CONN_TIMEOUT"
+	httpMaxIdleConns          = "HTTP_MAX_IDLE_CONNS"
+	httpMaxIdleConnsPerHost   = "HTTP_MAX_IDLE_CONNS_PER_HOST"
+	httpMaxConnsPerHost       = "HTTP_MAX_CONNS_PER_HOST"
+	httpProxyURL              = "HTTP_PROXY_URL"
+	httpRootCAs               = "HTTP_ROOT_CAS"
+	httpUserAgent             = "HTTP_USER_AGENT"
+	httpCache                 = "HTTP_CACHE"
+	httpCacheTTL              = "HTTP_CACHE_TTL"
+	httpCacheMaxEntries       = "HTTP_CACHE_MAX_ENTRIES"
+	httpCacheMaxEntrySize     = "HTTP_CACHE_MAX_ENTRY_SIZE"
+
+	fetcherWorkers          = "FETCHER_WORKERS"
+	fetcherMaxInFlight      = "FETCHER_MAX_IN_FLIGHT"
+	fetcherHistoryRetention = "FETCHER_HISTORY_RETENTION"
+	fetcherMaxBodySize      = "FETCHER_MAX_BODY_SIZE"
+	fetcherShutdownTimeout  = "FETCHER_SHUTDOWN_TIMEOUT"
+
+	fetcherBreakerFailureThreshold = "FETCHER_BREAKER_FAILURE_THRESHOLD"
+	fetcherBreakerOpenTimeout      = "FETCHER_BREAKER_OPEN_TIMEOUT"
+	fetcherBreakerHalfOpenRequests = "FETCHER_BREAKER_HALF_OPEN_REQUESTS"
+
+	leaderElectionKey      = "LEADER_ELECTION_KEY"
+	leaderElectionInterval = "LEADER_ELECTION_INTERVAL"
+
+	defaultLeaderElectionInterval = 5 * time.Second
+	defaultFetcherShutdownTimeout = 30 * time.Second
+
+	httpCacheMemory   = "memory"
+	httpCachePostgres = "postgres"
+
+	// migrationModeVersioned applies the pending versioned migrations on start, migrationModeAuto
+	// additionally runs AutoMigrate of the registered models for development, migrationModeOff
+	// leaves migrations to a separate deploy step.
+	migrationModeVersioned = "versioned"
+	migrationModeAuto      = "auto"
+	migrationModeOff       = "off"
 
 	logStr = "[APP] %s"
 )
@@ -47,10 +98,14 @@ type Application struct {
 	name string
 	env  env.Env
 
-	fetcher *httpfetcher.Fetcher
-	http    *echo.Echo
-	DB      *postgres.DB
-	tp      *trace.TracerProvider
+	fetcher    *httpfetcher.Fetcher
+	httpClient *httpclient.Client
+	http       *echo.Echo
+	DB         *postgres.DB
+	tp         *trace.TracerProvider
+
+	// resign releases the leader lock, it is set if leader election is enabled.
+	resign func()
 
 	closers  []Closer
 	dbModels []interface{}
@@ -65,13 +120,13 @@ func NewApplication() *Application {
 		env:      env.GetEnv(),
 		shutdown: make(chan os.Signal, 1),
 		httpErr:  make(chan error, 1),
-		fetcher:  httpfetcher.NewFetcher(),
 	}
 
-	app.closers = append(app.closers, app.fetcher.Close)
-
 	app.initTracing()
 	app.initDB()
+	app.initHTTPClient()
+	app.initFetcher()
+	app.initFetcherStore()
 
 	return app
 }
@@ -80,10 +135,22 @@ func (a *Application) RegisterFetchers(handlers ...httpfetcher.Handler) {
 	a.fetcher.RegisterHandlers(handlers...)
 }
 
+// Fetcher allows to add, remove and pause fetchers at runtime.
+func (a *Application) Fetcher() *httpfetcher.Fetcher {
+	return a.fetcher
+}
+
+// HTTPClient is the client for upstream APIs shared with the fetcher, it uses the response cache if enabled.
+func (a *Application) HTTPClient() *httpclient.Client {
+	return a.httpClient
+}
+
 func (a *Application) RegisterHTTP(e *echo.Echo) {
 	a.http = e
 }
 
+// RegisterMigrationModel registers models for AutoMigrate, which only runs in the auto migration mode.
+// Schema changes are shipped as versioned migrations in the migrations package.
 func (a *Application) RegisterMigrationModel(models ...interface{}) {
 	a.dbModels = append(a.dbModels, models...)
 }
@@ -95,7 +162,8 @@ func (a *Application) Run() {
 
 	a.migrationDB()
 
-	go a.fetcher.Run()
+	a.runLeaderElection()
+	go a.fetcher.Run(context.Background())
 	go a.serverHTTP()
 
 	log.GetLogger().Info(context.Background(), fmt.Sprintf(logStr, "Started"))
@@ -110,65 +178,251 @@ func (a *Application) Run() {
 	a.stop()
 }
 
+func (a *Application) initFetcher() {
+	cfg := httpfetcher.Config{
+		Workers:     a.env.GetInt(fetcherWorkers),
+		MaxInFlight: a.env.GetInt(fetcherMaxInFlight),
+		MaxBodySize: int64(a.env.GetInt(fetcherMaxBodySize)),
+		Breaker: httpfetcher.BreakerConfig{
+			FailureThreshold: a.env.GetInt(fetcherBreakerFailureThreshold),
+			OpenTimeout:      a.env.GetDuration(fetcherBreakerOpenTimeout),
+			HalfOpenRequests: a.env.GetInt(fetcherBreakerHalfOpenRequests),
+		},
+		HTTPClient: a.httpClient,
+	}
+
+	a.fetcher = httpfetcher.NewFetcher(cfg)
+	a.closers = append(a.closers, a.closeFetcher)
+}
+
+// initHTTPClient creates a client for upstream APIs, User-Agent defaults to the application name and version.
+func (a *Application) initHTTPClient() {
+	limits, err := httpclient.ParseRateLimits(a.env.GetString(httpRateLimits))
+	if err != nil {
+		panic(err)
+	}
+
+	auths, err := httpclient.ParseAuth(a.env.GetString(httpAuth))
+	if err != nil {
+		panic(err)
+	}
+
+	cfg := httpclient.Config{
+		DialTimeout:           a.env.GetDuration(httpDialTimeout),
+		TLSHandshakeTimeout:   a.env.GetDuration(httpTLSHandshakeTimeout),
+		ResponseHeaderTimeout: a.env.GetDuration(httpResponseHeaderTimeout),
+		IdleConnTimeout:       a.env.GetDuration(httpIdleConnTimeout),
+		MaxIdleConns:          a.env.GetInt(httpMaxIdleConns),
+		MaxIdleConnsPerHost:   a.env.GetInt(httpMaxIdleConnsPerHost),
+		MaxConnsPerHost:       a.env.GetInt(httpMaxConnsPerHost),
+		ProxyURL:              a.env.GetString(httpProxyURL),
+		UserAgent:             a.env.GetString(httpUserAgent),
+	}
+	if rootCAs := a.env.GetString(httpRootCAs); rootCAs != "" {
+		cfg.RootCAFiles = strings.Split(rootCAs, ",")
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = fmt.Sprintf("%s/%s", a.name, a.env.GetString(appVersionEnv))
+	}
+
+	opts := []httpclient.ClientOption{
+		httpclient.WithRateLimiter(httpclient.NewRateLimiter(limits)),
+		httpclient.WithAuth(httpclient.NewAuthenticator(auths)),
+	}
+	if cache := a.newHTTPCache(); cache != nil {
+		opts = append(opts, httpclient.WithCache(cache))
+	}
+
+	client, err := httpclient.NewClientFromConfig(cfg, opts...)
+	if err != nil {
+		panic(err)
+	}
+
+	a.httpClient = client
+}
+
+// newHTTPCache returns the response cache selected by HTTP_CACHE, nil if it is disabled.
+func (a *Application) newHTTPCache() *httpclient.Cache {
+	cfg := httpclient.CacheConfig{
+		TTL:          a.env.GetDuration(httpCacheTTL),
+		MaxEntrySize: int64(a.env.GetInt(httpCacheMaxEntrySize)),
+	}
+
+	switch storage := a.env.GetString(httpCache); storage {
+	case "":
+		return nil
+	case httpCacheMemory:
+		return httpclient.NewCache(httpclient.NewMemoryCache(a.env.GetInt(httpCacheMaxEntries)), cfg)
+	case httpCachePostgres:
+		a.RegisterMigrationModel(httpclient.CacheModels()...)
+		return httpclient.NewCache(httpclient.NewDBCache(a.DB), cfg)
+	default:
+		panic(fmt.Errorf("unknown http cache storage %q", storage))
+	}
+}
+
+// closeFetcher lets in-flight handlers finish within the shutdown timeout, the rest are cancelled.
+// The leader lock is released only after that, so that another instance does not start the same
+// imports while the runs of this one are still writing.
+func (a *Application) closeFetcher() error {
+	timeout := a.env.GetDuration(fetcherShutdownTimeout)
+	if timeout <= 0 {
+		timeout = defaultFetcherShutdownTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if a.resign != nil {
+		a.fetcher.SetActive(false)
+		defer a.resign()
+	}
+
+	return a.fetcher.Close(ctx)
+}
+
+// runLeaderElection keeps the fetcher in standby unless this instance holds the leader lock.
+// Leader election is disabled if the lock key is not set.
+func (a *Application) runLeaderElection() {
+	key := a.env.GetInt(leaderElectionKey)
+	if key == 0 {
+		return
+	}
+
+	interval := a.env.GetDuration(leaderElectionInterval)
+	if interval <= 0 {
+		interval = defaultLeaderElectionInterval
+	}
+
+	a.fetcher.SetActive(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		a.DB.Campaign(ctx, int64(key), interval, func(ctx context.Context, leader bool) {
+			a.fetcher.SetActive(leader)
+		})
+	}()
+
+	// The lock is released by closeFetcher once the fetcher is drained and before the DB is closed,
+	// so another instance takes over without waiting for the session timeout.
+	a.resign = func() {
+		cancel()
+		<-done
+	}
+}
+
+func (a *Application) initFetcherStore() {
+	a.fetcher.SetStore(httpfetcher.NewDBStore(a.DB, a.env.GetDuration(fetcherHistoryRetention)))
+	a.RegisterMigrationModel(httpfetcher.StoreModels()...)
+}
+
 func (a *Application) initDB() {
+	if err := a.openDB(context.Background()); err != nil {
+		panic(err)
+	}
+	a.closers = append(a.closers, a.DB.Close)
+}
+
+// openDB connects to the DB configured in the environment and waits until it is up.
+func (a *Application) openDB(ctx context.Context) error {
 	cfg := postgres.Config{
 		DSN:             a.env.GetString(postgresDSN),
 		MaxIdleConns:    a.env.GetInt(postgresMaxIdleConns),
 		MaxOpenConns:    a.env.GetInt(postgresMaxOpenConns),
 		ConnMaxLifetime: a.env.GetDuration(postgresConnMaxLifetime),
 		ConnMaxIdleTime: a.env.GetDuration(postgresConnMaxIdleTime),
+
+		ReplicaHealthInterval: a.env.GetDuration(postgresReplicaHealthInterval),
+	}
+	// DSNs may contain commas in options, so the replicas are separated with semicolons.
+	for _, dsn := range strings.Split(a.env.GetString(postgresReplicaDSNs), ";") {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			cfg.ReplicaDSNs = append(cfg.ReplicaDSNs, dsn)
+		}
 	}
 
 	db, err := postgres.NewDB(cfg)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
+	if err = waitDB(ctx, db); err != nil {
+		_ = db.Close()
+		return err
+	}
 	a.DB = db
-	a.closers = append(a.closers, a.DB.Close)
 
-	a.waitDB()
+	return nil
 }
 
-func (a *Application) waitDB() {
-	log.GetLogger().Info(context.Background(), fmt.Sprintf(logStr, "Waiting DB up..."))
+func waitDB(ctx context.Context, db *postgres.DB) error {
+	log.GetLogger().Info(ctx, fmt.Sprintf(logStr, "Waiting DB up..."))
 
 	check := func() error {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 		defer cancel()
 
-		err := a.DB.Ping(ctx)
+		err := db.Ping(ctx)
 		if err != nil {
-			log.GetLogger().Error(context.Background(), fmt.Sprintf(logStr, "Waiting DB up"), zap.Error(err))
+			log.GetLogger().Error(ctx, fmt.Sprintf(logStr, "Waiting DB up"), zap.Error(err))
 		} else {
-			log.GetLogger().Info(context.Background(), fmt.Sprintf(logStr, "DB up"))
+			log.GetLogger().Info(ctx, fmt.Sprintf(logStr, "DB up"))
 		}
 		return err
 	}
 
 	err := check()
 	if err == nil {
-		return
+		return nil
 	}
 
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
 	for i := 0; i < 5; i++ {
-		<-ticker.C
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
 		if err = check(); err == nil {
-			return
+			return nil
 		}
 	}
-	if err != nil {
-		panic(fmt.Errorf("wait DB up timeout"))
-	}
+
+	return fmt.Errorf("wait DB up timeout: %w", err)
 }
 
+// migrationDB applies the pending versioned migrations according to PG_MIGRATION_MODE (versioned by default).
 func (a *Application) migrationDB() {
-	if err := a.DB.Gorm.AutoMigrate(a.dbModels...); err != nil {
+	mode := a.env.GetString(postgresMigrationMode)
+	switch mode {
+	case "", migrationModeVersioned, migrationModeAuto:
+	case migrationModeOff:
+		return
+	default:
+		panic(fmt.Errorf("unknown migration mode %q", mode))
+	}
+
+	migrator, err := postgres.NewMigrator(a.DB, migrations.FS)
+	if err != nil {
+		panic(fmt.Errorf("db migration failed: %w", err))
+	}
+
+	count, err := migrator.Up(context.Background())
+	if err != nil {
 		panic(fmt.Errorf("db migration failed: %w", err))
 	}
+	log.GetLogger().Info(context.Background(), fmt.Sprintf(logStr, "DB migrated"), zap.Int("applied", count))
+
+	if mode == migrationModeAuto {
+		if err = a.DB.Gorm.AutoMigrate(a.dbModels...); err != nil {
+			panic(fmt.Errorf("db auto migration failed: %w", err))
+		}
+	}
 }
 
 func (a *Application) serverHTTP() {
@@ -176,6 +430,7 @@ func (a *Application) serverHTTP() {
 		middleware.TracingMiddleware(a.name),
 		middleware.LoggingMiddleware(),
 		middleware.RecoveringMiddleware(),
+		middleware.AdminAuthMiddleware("/admin", a.env.GetString(adminTokenEnv)),
 	)
 
 	a.http.GET("/metrics", func(c echo.Context) error {
@@ -189,6 +444,14 @@ func (a *Application) serverHTTP() {
 }
 
 func (a *Application) stop() {
+	// Closers run in reverse order of registration, like deferred calls.
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		if err := a.closers[i](); err != nil {
+			log.GetLogger().Error(context.Background(), fmt.Sprintf(logStr, "Shutdown error"), zap.Error(err))
+		}
+	}
+
+	// Spans of the handlers drained by the closers are flushed last.
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -196,12 +459,6 @@ func (a *Application) stop() {
 		log.GetLogger().Error(context.Background(), fmt.Sprintf(logStr, "TracerProvider shutdown error"), zap.Error(err))
 	}
 
-	for _, closer := range a.closers {
-		if err := closer(); err != nil {
-			log.GetLogger().Error(context.Background(), fmt.Sprintf(logStr, "Shutdown error"), zap.Error(err))
-		}
-	}
-
 	_ = log.GetLogger().Sync()
 }
 
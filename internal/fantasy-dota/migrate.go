@@ -0,0 +1,135 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/redrru/fantasy-dota/internal/fantasy-dota/migrations"
+	postgres "github.com/redrru/fantasy-dota/pkg/db"
+	"github.com/redrru/fantasy-dota/pkg/env"
+)
+
+// migrationsDir is the source directory of the migrations package relative to the repository root.
+const migrationsDir = "internal/fantasy-dota/migrations"
+
+const migrateUsage = `usage: fantasy-dota migrate <command>
+
+commands:
+  up             apply the pending migrations
+  down [N]       revert the last N applied migrations (1 by default)
+  status         list the migrations and when they were applied
+  create <name>  create empty up and down migration files in ` + migrationsDir + `,
+                 the repository is searched from the current directory up`
+
+// Migrate runs the migration command args and writes its result to out. Only the DB is initialized
+// from the environment, the HTTP server, the fetcher and the tracing exporter are not started,
+// so migrations can run as a separate deploy step.
+func Migrate(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch command := args[0]; command {
+	case "create":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+
+		dir, err := findMigrationsDir()
+		if err != nil {
+			return err
+		}
+
+		paths, err := postgres.CreateMigration(dir, args[1])
+		for _, path := range paths {
+			fmt.Fprintf(out, "created %s\n", path)
+		}
+		return err
+	case "up", "down", "status":
+	default:
+		return fmt.Errorf("unknown migrate command %q\n\n%s", command, migrateUsage)
+	}
+
+	n := 1
+	if args[0] == "down" && len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n <= 0 {
+			return fmt.Errorf("down: N must be a positive number, got %q", args[1])
+		}
+	}
+
+	a := &Application{env: env.GetEnv()}
+	if err := a.openDB(ctx); err != nil {
+		return fmt.Errorf("connect to DB: %w", err)
+	}
+	defer a.DB.Close()
+
+	migrator, err := postgres.NewMigrator(a.DB, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		count, err := migrator.Up(ctx)
+		fmt.Fprintf(out, "applied %d migrations\n", count)
+		return err
+	case "down":
+		count, err := migrator.Down(ctx, n)
+		fmt.Fprintf(out, "reverted %d migrations\n", count)
+		return err
+	default:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		return writeMigrationStatus(out, statuses)
+	}
+}
+
+// findMigrationsDir returns migrationsDir of the repository containing the current directory.
+func findMigrationsDir() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		path := filepath.Join(dir, migrationsDir)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return path, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("%s not found, run the command inside the repository", migrationsDir)
+		}
+		dir = parent
+	}
+}
+
+func writeMigrationStatus(out io.Writer, statuses []postgres.MigrationStatus) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+
+	for _, status := range statuses {
+		state, appliedAt, name := "pending", "", status.Name
+		if status.Applied() {
+			state, appliedAt = "applied", status.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		if name == "" {
+			// Applied by a newer release, its files are not in this binary.
+			name, state = "?", "unknown"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", status.Version, name, state, appliedAt)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS example (
+    id   bigserial PRIMARY KEY,
+    name text
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS fetcher_checkpoints;
+DROP TABLE IF EXISTS fetcher_runs;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS fetcher_runs (
+    id          bigserial PRIMARY KEY,
+    handler     text        NOT NULL,
+    url         text        NOT NULL,
+    started_at  timestamptz NOT NULL,
+    duration_ms bigint      NOT NULL,
+    error       text
+);
+
+CREATE INDEX IF NOT EXISTS idx_fetcher_runs_handler_started_at ON fetcher_runs (handler, started_at);
+
+CREATE TABLE IF NOT EXISTS fetcher_checkpoints (
+    handler    text PRIMARY KEY,
+    data       bytea,
+    updated_at timestamptz
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS http_cache (
+    key           text PRIMARY KEY,
+    response      bytea       NOT NULL,
+    vary          bytea,
+    request_time  timestamptz NOT NULL,
+    response_time timestamptz NOT NULL,
+    expires_at    timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_http_cache_expires_at ON http_cache (expires_at);
@@ -0,0 +1,11 @@
+// Package migrations holds the versioned SQL migrations of the application schema.
+//
+// A migration is a pair of files "<version>_<name>.up.sql" and "<version>_<name>.down.sql",
+// versions are applied in ascending order. The first migrations create their tables only if they
+// do not exist, so that databases created by AutoMigrate are taken over as is.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,23 @@
+//go:build unit
+// +build unit
+
+package migrations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/redrru/fantasy-dota/pkg/db"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := db.LoadMigrations(FS)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+
+	for i, migration := range migrations {
+		assert.Equal(t, int64(i+1), migration.Version, "versions must not have gaps")
+		assert.NotEmpty(t, migration.Down, "migration %d_%s has no down migration", migration.Version, migration.Name)
+	}
+}
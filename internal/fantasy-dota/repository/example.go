@@ -13,7 +13,7 @@ func (r *Repository) ExampleList(ctx context.Context) ([]entity.ExampleModel, er
 
 	var models []entity.ExampleModel
 
-	err := r.db.Gorm.WithContext(ctx).Find(&models).Error
+	err := r.db.Conn(ctx).Find(&models).Error
 	if err != nil {
 		return nil, err
 	}
@@ -25,5 +25,5 @@ func (r *Repository) ExampleCreate(ctx context.Context, model entity.ExampleMode
 	ctx, span := tracing.DefaultTracer().Start(ctx, "ExampleCreate")
 	defer span.End()
 
-	return r.db.Gorm.WithContext(ctx).Create(&model).Error
+	return r.db.Conn(ctx).Create(&model).Error
 }
@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"context"
+
 	postgres "github.com/redrru/fantasy-dota/pkg/db"
 )
 
@@ -11,3 +13,8 @@ type Repository struct {
 func NewRepository(db *postgres.DB) *Repository {
 	return &Repository{db: db}
 }
+
+// WithinTx runs fn in a transaction, the repository methods called with the ctx of fn take part in it.
+func (r *Repository) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.db.WithinTx(ctx, fn)
+}
@@ -4,9 +4,17 @@ import (
 	"context"
 
 	"github.com/redrru/fantasy-dota/internal/fantasy-dota/entity"
+	"github.com/redrru/fantasy-dota/pkg/fetcher"
 )
 
 type repository interface {
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+
 	ExampleList(ctx context.Context) ([]entity.ExampleModel, error)
 	ExampleCreate(ctx context.Context, model entity.ExampleModel) error
 }
+
+type fetcherRegistry interface {
+	Status() []fetcher.HandlerStatus
+	Trigger(name string) error
+}
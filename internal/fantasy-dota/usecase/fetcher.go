@@ -0,0 +1,22 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/redrru/fantasy-dota/pkg/fetcher"
+	"github.com/redrru/fantasy-dota/pkg/tracing"
+)
+
+func (u *Usecase) FetcherList(ctx context.Context) []fetcher.HandlerStatus {
+	_, span := tracing.DefaultTracer().Start(ctx, "FetcherList")
+	defer span.End()
+
+	return u.fetcher.Status()
+}
+
+func (u *Usecase) FetcherRun(ctx context.Context, name string) error {
+	_, span := tracing.DefaultTracer().Start(ctx, "FetcherRun")
+	defer span.End()
+
+	return u.fetcher.Trigger(name)
+}
@@ -1,9 +1,10 @@
 package usecase
 
 type Usecase struct {
-	repo repository
+	repo    repository
+	fetcher fetcherRegistry
 }
 
-func NewUsecase(repo repository) *Usecase {
-	return &Usecase{repo: repo}
+func NewUsecase(repo repository, fetcher fetcherRegistry) *Usecase {
+	return &Usecase{repo: repo, fetcher: fetcher}
 }
@@ -4,9 +4,12 @@ import (
 	"context"
 
 	"github.com/redrru/fantasy-dota/internal/fantasy-dota/entity"
+	"github.com/redrru/fantasy-dota/pkg/fetcher"
 )
 
 type usecase interface {
 	ExampleGet(ctx context.Context) ([]entity.ExampleModel, error)
 	ExamplePost(ctx context.Context, model entity.ExampleModel) error
+	FetcherList(ctx context.Context) []fetcher.HandlerStatus
+	FetcherRun(ctx context.Context, name string) error
 }
@@ -4,6 +4,7 @@
 package http
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -19,7 +20,7 @@ func TestGetExample(t *testing.T) {
 	rec := httptest.NewRecorder()
 	c := echo.New().NewContext(req, rec)
 
-	srv := server.ServerInterfaceWrapper{Handler: NewServer()}
+	srv := server.ServerInterfaceWrapper{Handler: NewServer(&stubUsecase{exampleErr: errors.New("db is down")})}
 
 	err := srv.GetExample(c)
 	assert.Error(t, err)
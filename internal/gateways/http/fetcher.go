@@ -0,0 +1,90 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/redrru/fantasy-dota/pkg/fetcher"
+	"github.com/redrru/fantasy-dota/pkg/server"
+	"github.com/redrru/fantasy-dota/pkg/tracing"
+)
+
+// GetAdminFetchers - List registered fetchers.
+// (GET /admin/fetchers)
+func (s *Server) GetAdminFetchers(c echo.Context) error {
+	ctx, span := tracing.DefaultTracer().Start(c.Request().Context(), "GetAdminFetchers")
+	defer span.End()
+
+	result := server.FetcherListResponse{}
+	for _, status := range s.usecase.FetcherList(ctx) {
+		result = append(result, fetcherObject(status))
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// PostAdminFetchersRun - Run fetcher immediately.
+// (POST /admin/fetchers/run)
+func (s *Server) PostAdminFetchersRun(c echo.Context) error {
+	ctx, span := tracing.DefaultTracer().Start(c.Request().Context(), "PostAdminFetchersRun")
+	defer span.End()
+
+	req := new(server.PostAdminFetchersRunJSONRequestBody)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	if req.Name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "empty name")
+	}
+
+	err := s.usecase.FetcherRun(ctx, req.Name)
+	if errors.Is(err, fetcher.ErrHandlerNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if errors.Is(err, fetcher.ErrStandby) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+func fetcherObject(status fetcher.HandlerStatus) server.FetcherObject {
+	obj := server.FetcherObject{
+		Name:        status.Name,
+		Url:         status.URL,
+		Paused:      status.Paused,
+		RefreshTime: status.RefreshTime.String(),
+		Runs:        status.Runs,
+		LastRun:     timePtr(status.LastRun),
+		LastSuccess: timePtr(status.LastSuccess),
+		NextRun:     timePtr(status.NextRun),
+	}
+
+	if status.Schedule != "" {
+		schedule := status.Schedule
+		obj.Schedule = &schedule
+	}
+	if status.Runs > 0 {
+		duration := status.LastDuration.String()
+		obj.LastDuration = &duration
+	}
+	if status.LastErr != nil {
+		lastErr := status.LastErr.Error()
+		obj.LastError = &lastErr
+	}
+
+	return obj
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
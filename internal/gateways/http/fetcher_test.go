@@ -0,0 +1,177 @@
+//go:build unit
+// +build unit
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/redrru/fantasy-dota/internal/fantasy-dota/entity"
+	"github.com/redrru/fantasy-dota/pkg/fetcher"
+	"github.com/redrru/fantasy-dota/pkg/server"
+)
+
+type stubUsecase struct {
+	exampleErr error
+	statuses   []fetcher.HandlerStatus
+	runErr     error
+	ran        string
+}
+
+func (u *stubUsecase) ExampleGet(ctx context.Context) ([]entity.ExampleModel, error) {
+	return nil, u.exampleErr
+}
+
+func (u *stubUsecase) ExamplePost(ctx context.Context, model entity.ExampleModel) error {
+	return u.exampleErr
+}
+
+func (u *stubUsecase) FetcherList(ctx context.Context) []fetcher.HandlerStatus {
+	return u.statuses
+}
+
+func (u *stubUsecase) FetcherRun(ctx context.Context, name string) error {
+	u.ran = name
+	return u.runErr
+}
+
+func TestGetAdminFetchers(t *testing.T) {
+	lastRun := time.Date(2022, 5, 23, 10, 0, 0, 0, time.UTC)
+	uc := &stubUsecase{statuses: []fetcher.HandlerStatus{
+		{
+			RunState: fetcher.RunState{
+				Runs:         2,
+				LastRun:      lastRun,
+				LastDuration: 150 * time.Millisecond,
+				LastErr:      errors.New("503 Service Unavailable"),
+			},
+			Name:        "heroes",
+			URL:         "https://api.opendota.com/api/heroes",
+			RefreshTime: time.Minute,
+		},
+		{
+			Name:     "matches",
+			Schedule: "0 4 * * * UTC",
+		},
+	}}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/fetchers", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	srv := server.ServerInterfaceWrapper{Handler: NewServer(uc)}
+	assert.NoError(t, srv.GetAdminFetchers(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var result server.FetcherListResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+
+	lastDuration, lastError, schedule := "150ms", "503 Service Unavailable", "0 4 * * * UTC"
+	assert.Equal(t, server.FetcherListResponse{
+		{
+			Name:         "heroes",
+			Url:          "https://api.opendota.com/api/heroes",
+			RefreshTime:  "1m0s",
+			Runs:         2,
+			LastRun:      &lastRun,
+			LastDuration: &lastDuration,
+			LastError:    &lastError,
+		},
+		{
+			Name:        "matches",
+			RefreshTime: "0s",
+			Schedule:    &schedule,
+		},
+	}, result)
+}
+
+func TestPostAdminFetchersRun(t *testing.T) {
+	errDB := errors.New("db is down")
+
+	testCases := []struct {
+		name     string
+		body     string
+		runErr   error
+		wantCode int
+		wantErr  error
+		wantRan  string
+	}{
+		{
+			name:     "Accepted",
+			body:     `{"name": "heroes"}`,
+			wantCode: http.StatusAccepted,
+			wantRan:  "heroes",
+		},
+		{
+			name:     "NotFound",
+			body:     `{"name": "unknown"}`,
+			runErr:   fetcher.ErrHandlerNotFound,
+			wantCode: http.StatusNotFound,
+			wantRan:  "unknown",
+		},
+		{
+			name:     "Standby",
+			body:     `{"name": "heroes"}`,
+			runErr:   fetcher.ErrStandby,
+			wantCode: http.StatusConflict,
+			wantRan:  "heroes",
+		},
+		{
+			name:     "InvalidBody",
+			body:     `{"name": `,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "EmptyName",
+			body:     `{}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:    "Error",
+			body:    `{"name": "heroes"}`,
+			runErr:  errDB,
+			wantErr: errDB,
+			wantRan: "heroes",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/fetchers/run", strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			uc := &stubUsecase{runErr: tc.runErr}
+			srv := server.ServerInterfaceWrapper{Handler: NewServer(uc)}
+			err := srv.PostAdminFetchersRun(c)
+
+			switch {
+			case tc.wantErr != nil:
+				assert.ErrorIs(t, err, tc.wantErr)
+			case tc.wantCode == http.StatusAccepted:
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantCode, rec.Code)
+			default:
+				var httpErr *echo.HTTPError
+				if assert.True(t, errors.As(err, &httpErr), err) {
+					assert.Equal(t, tc.wantCode, httpErr.Code)
+				}
+			}
+			assert.Equal(t, tc.wantRan, uc.ran)
+		})
+	}
+}
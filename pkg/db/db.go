@@ -21,15 +21,23 @@ type Config struct {
 	MaxOpenConns    int
 	ConnMaxLifetime time.Duration
 	ConnMaxIdleTime time.Duration
+
+	// ReplicaDSNs are the read replicas, reads outside of transactions are routed to the healthy ones.
+	ReplicaDSNs []string
+	// ReplicaHealthInterval is how often the replicas are pinged, 10s by default.
+	ReplicaHealthInterval time.Duration
 }
 
 type DB struct {
-	Gorm *gorm.DB
-	sql  *sql.DB
+	Gorm     *gorm.DB
+	sql      *sql.DB
+	replicas *replicas
 }
 
 func NewDB(config Config) (*DB, error) {
-	log.GetLogger().Info(context.Background(), "[DB] New", zap.Any("config", config))
+	log.GetLogger().Info(context.Background(), "[DB] New", zap.Int("replicas", len(config.ReplicaDSNs)),
+		zap.Int("max_idle_conns", config.MaxIdleConns), zap.Int("max_open_conns", config.MaxOpenConns),
+		zap.Duration("conn_max_lifetime", config.ConnMaxLifetime), zap.Duration("conn_max_idle_time", config.ConnMaxIdleTime))
 
 	_, err := pgx.ParseConfig(config.DSN)
 	if err != nil {
@@ -52,15 +60,41 @@ func NewDB(config Config) (*DB, error) {
 		return nil, fmt.Errorf("get generic db object failed: %w", err)
 	}
 
+	setPool(sqlDB, config)
+	registerPool(primaryPool, sqlDB)
+
+	if err := registerQueryMetrics(orm); err != nil {
+		return nil, fmt.Errorf("register query metrics failed: %w", err)
+	}
+
+	db := &DB{Gorm: orm, sql: sqlDB}
+
+	if len(config.ReplicaDSNs) > 0 {
+		if db.replicas, err = newReplicas(sqlDB, config); err != nil {
+			return nil, err
+		}
+		if err = db.replicas.register(orm); err != nil {
+			_ = db.replicas.close()
+			return nil, fmt.Errorf("register replica routing failed: %w", err)
+		}
+	}
+
+	return db, nil
+}
+
+func setPool(sqlDB *sql.DB, config Config) {
 	sqlDB.SetMaxOpenConns(config.MaxOpenConns)
 	sqlDB.SetMaxIdleConns(config.MaxIdleConns)
 	sqlDB.SetConnMaxLifetime(config.ConnMaxLifetime)
 	sqlDB.SetConnMaxIdleTime(config.ConnMaxIdleTime)
-
-	return &DB{Gorm: orm, sql: sqlDB}, nil
 }
 
 func (db *DB) Close() error {
+	if db.replicas != nil {
+		if err := db.replicas.close(); err != nil {
+			log.GetLogger().Warn(context.Background(), fmt.Sprintf(logStr, "Close replicas"), zap.Error(err))
+		}
+	}
 	return db.sql.Close()
 }
 
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"github.com/redrru/fantasy-dota/pkg/log"
+)
+
+var (
+	leaderGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "db",
+		Name:      "leader",
+		Help:      "1 if this instance holds the leader advisory lock.",
+	}, []string{"key"})
+
+	leaderChangesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "db",
+		Name:      "leader_changes_total",
+		Help:      "Number of leadership acquisitions and losses.",
+	}, []string{"key"})
+)
+
+// lockSession is the dedicated connection of a campaign holding the advisory lock.
+type lockSession interface {
+	tryLock(ctx context.Context, key int64) (bool, error)
+	unlock(ctx context.Context, key int64) error
+	ping(ctx context.Context) error
+	Close() error
+}
+
+type connSession struct {
+	*sql.Conn
+}
+
+func (s connSession) tryLock(ctx context.Context, key int64) (bool, error) {
+	var acquired bool
+	err := s.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired)
+	return acquired, err
+}
+
+func (s connSession) unlock(ctx context.Context, key int64) error {
+	_, err := s.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", key)
+	return err
+}
+
+func (s connSession) ping(ctx context.Context) error {
+	return s.PingContext(ctx)
+}
+
+type campaign struct {
+	connect  func(ctx context.Context) (lockSession, error)
+	key      int64
+	onChange func(ctx context.Context, leader bool)
+
+	conn   lockSession
+	leader bool
+	logger log.Logger
+}
+
+// Campaign competes for leadership using the Postgres session advisory lock key until ctx is done.
+// The lock is held on a dedicated connection which is checked every interval, onChange is called
+// whenever leadership is acquired or lost. If the leader dies its session ends and another instance
+// takes the lock on its next attempt.
+func (db *DB) Campaign(ctx context.Context, key int64, interval time.Duration, onChange func(ctx context.Context, leader bool)) {
+	c := newCampaign(key, onChange, func(ctx context.Context) (lockSession, error) {
+		conn, err := db.sql.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return connSession{conn}, nil
+	})
+	defer c.resign()
+
+	leaderGauge.WithLabelValues(c.label()).Set(0)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.step(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func newCampaign(key int64, onChange func(ctx context.Context, leader bool), connect func(ctx context.Context) (lockSession, error)) *campaign {
+	return &campaign{
+		connect:  connect,
+		key:      key,
+		onChange: onChange,
+		logger:   log.GetLogger().With(zap.Int64("key", key)),
+	}
+}
+
+// step checks that the held lock session is alive or tries to acquire the lock.
+func (c *campaign) step(ctx context.Context) {
+	if c.conn == nil {
+		conn, err := c.connect(ctx)
+		if err != nil {
+			c.logger.Error(ctx, fmt.Sprintf(logStr, "Leader election connection"), zap.Error(err))
+			return
+		}
+		c.conn = conn
+	}
+
+	if c.leader {
+		if err := c.conn.ping(ctx); err != nil {
+			c.logger.Error(ctx, fmt.Sprintf(logStr, "Leader session lost"), zap.Error(err))
+			c.closeConn()
+			c.setLeader(ctx, false)
+		}
+		return
+	}
+
+	acquired, err := c.conn.tryLock(ctx, c.key)
+	if err != nil {
+		c.logger.Error(ctx, fmt.Sprintf(logStr, "Try advisory lock"), zap.Error(err))
+		c.closeConn()
+		return
+	}
+
+	c.setLeader(ctx, acquired)
+}
+
+func (c *campaign) setLeader(ctx context.Context, leader bool) {
+	if c.leader == leader {
+		return
+	}
+	c.leader = leader
+
+	leaderChangesTotal.WithLabelValues(c.label()).Inc()
+	if leader {
+		leaderGauge.WithLabelValues(c.label()).Set(1)
+		c.logger.Info(ctx, fmt.Sprintf(logStr, "Leadership acquired"))
+	} else {
+		leaderGauge.WithLabelValues(c.label()).Set(0)
+		c.logger.Warn(ctx, fmt.Sprintf(logStr, "Leadership lost"))
+	}
+
+	c.onChange(ctx, leader)
+}
+
+// resign releases the lock so that another instance does not have to wait for the session to end.
+func (c *campaign) resign() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if c.leader {
+		if err := c.conn.unlock(ctx, c.key); err != nil {
+			c.logger.Warn(ctx, fmt.Sprintf(logStr, "Advisory unlock"), zap.Error(err))
+		}
+		c.setLeader(ctx, false)
+	}
+
+	c.closeConn()
+}
+
+func (c *campaign) closeConn() {
+	if c.conn == nil {
+		return
+	}
+
+	if err := c.conn.Close(); err != nil {
+		c.logger.Warn(context.Background(), fmt.Sprintf(logStr, "Close leader election connection"), zap.Error(err))
+	}
+	c.conn = nil
+}
+
+func (c *campaign) label() string {
+	return fmt.Sprint(c.key)
+}
@@ -0,0 +1,101 @@
+//go:build unit
+// +build unit
+
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubSession struct {
+	locks    []bool
+	lockErr  error
+	pingErr  error
+	unlocked bool
+	closed   bool
+}
+
+func (s *stubSession) tryLock(context.Context, int64) (bool, error) {
+	if s.lockErr != nil {
+		return false, s.lockErr
+	}
+	acquired := s.locks[0]
+	s.locks = s.locks[1:]
+	return acquired, nil
+}
+
+func (s *stubSession) unlock(context.Context, int64) error {
+	s.unlocked = true
+	return nil
+}
+
+func (s *stubSession) ping(context.Context) error {
+	return s.pingErr
+}
+
+func (s *stubSession) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestCampaign(t *testing.T) {
+	const key = 0x7465737431
+
+	first := &stubSession{locks: []bool{false, true}}
+	failing := &stubSession{lockErr: errors.New("connection reset")}
+	second := &stubSession{locks: []bool{true}}
+	sessions := []*stubSession{first, failing, second}
+
+	var active []bool
+	c := newCampaign(key, func(ctx context.Context, leader bool) {
+		active = append(active, leader)
+	}, func(ctx context.Context) (lockSession, error) {
+		s := sessions[0]
+		sessions = sessions[1:]
+		return s, nil
+	})
+	ctx := context.Background()
+	changes := testutil.ToFloat64(leaderChangesTotal.WithLabelValues(c.label()))
+
+	// Another instance holds the lock.
+	c.step(ctx)
+	assert.Empty(t, active)
+	assert.Equal(t, changes, testutil.ToFloat64(leaderChangesTotal.WithLabelValues(c.label())))
+
+	// Acquire.
+	c.step(ctx)
+	assert.Equal(t, []bool{true}, active)
+	assert.Equal(t, float64(1), testutil.ToFloat64(leaderGauge.WithLabelValues(c.label())))
+
+	// The session is alive, nothing changes.
+	c.step(ctx)
+	assert.Equal(t, []bool{true}, active)
+
+	// Lose the session.
+	first.pingErr = errors.New("connection lost")
+	c.step(ctx)
+	assert.Equal(t, []bool{true, false}, active)
+	assert.True(t, first.closed)
+	assert.Equal(t, float64(0), testutil.ToFloat64(leaderGauge.WithLabelValues(c.label())))
+
+	// The new session fails before the lock is taken, it stays a follower.
+	c.step(ctx)
+	assert.Equal(t, []bool{true, false}, active)
+	assert.True(t, failing.closed)
+
+	// Re-acquire on a new session.
+	c.step(ctx)
+	assert.Equal(t, []bool{true, false, true}, active)
+	assert.Equal(t, float64(1), testutil.ToFloat64(leaderGauge.WithLabelValues(c.label())))
+
+	c.resign()
+	assert.Equal(t, []bool{true, false, true, false}, active)
+	assert.True(t, second.unlocked)
+	assert.True(t, second.closed)
+	assert.Equal(t, changes+4, testutil.ToFloat64(leaderChangesTotal.WithLabelValues(c.label())))
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/redrru/fantasy-dota/pkg/log"
+)
+
+const (
+	primaryPool     = "primary"
+	queryStartKey   = "db:query_start"
+	rawQueryTable   = "raw"
+	metricsCallback = "db:metrics"
+)
+
+var (
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Latency of the gorm queries by query name.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"query"})
+
+	queryErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "db",
+		Name:      "query_errors_total",
+		Help:      "Number of failed gorm queries by query name, not found records are not counted.",
+	}, []string{"query"})
+)
+
+type queryNameKey struct{}
+
+// WithQueryName names the queries run with the returned context in the query metrics,
+// otherwise they are named "<table>.<operation>", e.g. "fetcher_runs.create" or "raw.row".
+func WithQueryName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryNameKey{}, name)
+}
+
+// registerPool exports sql.DBStats of the pool as go_sql_* metrics with the db_name label.
+func registerPool(name string, db *sql.DB) {
+	if err := prometheus.Register(collectors.NewDBStatsCollector(db, name)); err != nil {
+		log.GetLogger().Warn(context.Background(), fmt.Sprintf(logStr, "Register pool metrics"), zap.String("pool", name), zap.Error(err))
+	}
+}
+
+// registerQueryMetrics observes the duration and the errors of every query of orm.
+func registerQueryMetrics(orm *gorm.DB) error {
+	callbacks := orm.Callback()
+	start := metricsCallback + "_start"
+
+	for _, err := range []error{
+		callbacks.Create().Before("*").Register(start, startQuery),
+		callbacks.Create().After("*").Register(metricsCallback, observeQuery("create")),
+		callbacks.Query().Before("*").Register(start, startQuery),
+		callbacks.Query().After("*").Register(metricsCallback, observeQuery("query")),
+		callbacks.Update().Before("*").Register(start, startQuery),
+		callbacks.Update().After("*").Register(metricsCallback, observeQuery("update")),
+		callbacks.Delete().Before("*").Register(start, startQuery),
+		callbacks.Delete().After("*").Register(metricsCallback, observeQuery("delete")),
+		callbacks.Row().Before("*").Register(start, startQuery),
+		callbacks.Row().After("*").Register(metricsCallback, observeQuery("row")),
+		callbacks.Raw().Before("*").Register(start, startQuery),
+		callbacks.Raw().After("*").Register(metricsCallback, observeQuery("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func startQuery(tx *gorm.DB) {
+	tx.InstanceSet(queryStartKey, time.Now())
+}
+
+func observeQuery(operation string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		start, ok := tx.InstanceGet(queryStartKey)
+		if !ok {
+			return
+		}
+
+		name := queryName(tx, operation)
+		queryDuration.WithLabelValues(name).Observe(time.Since(start.(time.Time)).Seconds())
+		if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			queryErrorsTotal.WithLabelValues(name).Inc()
+		}
+	}
+}
+
+func queryName(tx *gorm.DB, operation string) string {
+	if name, ok := tx.Statement.Context.Value(queryNameKey{}).(string); ok && name != "" {
+		return name
+	}
+
+	table := tx.Statement.Table
+	if table == "" {
+		table = rawQueryTable
+	}
+	return table + "." + operation
+}
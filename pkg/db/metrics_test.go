@@ -0,0 +1,71 @@
+//go:build unit
+// +build unit
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type metricsModel struct {
+	ID int
+}
+
+func (m *metricsModel) TableName() string {
+	return "metrics_models"
+}
+
+func TestQueryMetrics(t *testing.T) {
+	sqlDB, err := sql.Open("pgx", "host=localhost port=1 dbname=test")
+	assert.NoError(t, err)
+	defer sqlDB.Close()
+
+	orm, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true, Logger: logger.Discard})
+	assert.NoError(t, err)
+	assert.NoError(t, registerQueryMetrics(orm))
+
+	names := []string{"metrics_models.query", "metrics_models.update", "MetricsList", "raw.row", "metrics_models.delete"}
+
+	// The metrics are global, so the test checks the increments and can run repeatedly.
+	samples := map[string]uint64{}
+	errs := map[string]float64{}
+	for _, name := range names {
+		samples[name] = querySamples(t, name)
+		errs[name] = testutil.ToFloat64(queryErrorsTotal.WithLabelValues(name))
+	}
+
+	ctx := context.Background()
+
+	orm.WithContext(ctx).Find(&[]metricsModel{})
+	orm.WithContext(ctx).Where("id = ?", 1).Updates(&metricsModel{ID: 2})
+	orm.WithContext(WithQueryName(ctx, "MetricsList")).Find(&[]metricsModel{})
+	orm.WithContext(ctx).Raw("SELECT 1").Scan(&[]metricsModel{})
+	// Delete without conditions fails with gorm.ErrMissingWhereClause.
+	orm.WithContext(ctx).Delete(&metricsModel{})
+
+	for _, name := range names {
+		assert.Equal(t, samples[name]+1, querySamples(t, name), name)
+
+		wantErrs := errs[name]
+		if name == "metrics_models.delete" {
+			wantErrs++
+		}
+		assert.Equal(t, wantErrs, testutil.ToFloat64(queryErrorsTotal.WithLabelValues(name)), name)
+	}
+}
+
+func querySamples(t *testing.T, name string) uint64 {
+	var m dto.Metric
+	assert.NoError(t, queryDuration.WithLabelValues(name).(prometheus.Metric).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
@@ -0,0 +1,342 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/redrru/fantasy-dota/pkg/log"
+)
+
+// MigrationLockKey is the advisory lock key held while migrating, so that replicas started
+// at the same time apply every migration once. It must differ from the leader election key.
+const MigrationLockKey int64 = 0x6d6967726174696f // "migratio"
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    bigint PRIMARY KEY,
+	name       text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`
+
+// migrationName matches the names accepted by CreateMigration.
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// migrationFile matches the migration file names "<version>_<name>.up.sql" and "<version>_<name>.down.sql".
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change, Down reverts Up and may be empty if the change is irreversible.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration with the time it was applied, AppliedAt is zero for pending migrations.
+// Name is empty and Up and Down are unknown for applied migrations missing in the migration files.
+type MigrationStatus struct {
+	Migration
+	AppliedAt time.Time
+}
+
+func (s MigrationStatus) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+// LoadMigrations reads the migrations "<version>_<name>.up.sql" and the optional "<version>_<name>.down.sql"
+// in the root of fsys sorted by version. Other files are ignored.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %s: version %d is used by %s", entry.Name(), version, migration.Name)
+		}
+
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", entry.Name(), err)
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: up migration is missing", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// CreateMigration creates empty up and down files of the migration name in dir with the version
+// following the last one, e.g. "0004_add_player_rank.up.sql", and returns their paths.
+func CreateMigration(dir, name string) ([]string, error) {
+	name = strings.ToLower(strings.NewReplacer(" ", "_", "-", "_").Replace(strings.TrimSpace(name)))
+	if !migrationName.MatchString(name) {
+		return nil, fmt.Errorf("invalid migration name %q, use letters, digits and underscores", name)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+
+	var last int64
+	for _, entry := range entries {
+		if match := migrationFile.FindStringSubmatch(entry.Name()); match != nil {
+			if version, err := strconv.ParseInt(match[1], 10, 64); err == nil && version > last {
+				last = version
+			}
+		}
+	}
+
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%04d_%s.%s.sql", last+1, name, direction))
+		content := fmt.Sprintf("-- %s migration %04d_%s\n", direction, last+1, name)
+
+		// O_EXCL keeps concurrent runs from overwriting each other's files.
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return paths, fmt.Errorf("create migration: %w", err)
+		}
+		_, err = file.WriteString(content)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return paths, fmt.Errorf("write migration: %w", err)
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// Migrator applies and reverts migrations and records the applied versions in schema_migrations.
+// Every migration runs in its own transaction together with its record.
+type Migrator struct {
+	db         *DB
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the migrations in the root of fsys, see LoadMigrations.
+func NewMigrator(db *DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Up applies the pending migrations in version order and returns how many were applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	var count int
+
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			if err := m.apply(ctx, conn, migration, true); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// Down reverts the last n applied migrations and returns how many were reverted.
+func (m *Migrator) Down(ctx context.Context, n int) (int, error) {
+	var count int
+
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for i := len(m.migrations) - 1; i >= 0 && count < n; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s is irreversible", migration.Version, migration.Name)
+			}
+
+			if err := m.apply(ctx, conn, migration, false); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// Status returns the known migrations and the applied migrations missing in the files, sorted by version.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, migration := range m.migrations {
+			statuses = append(statuses, MigrationStatus{Migration: migration, AppliedAt: applied[migration.Version]})
+			delete(applied, migration.Version)
+		}
+		for version, appliedAt := range applied {
+			statuses = append(statuses, MigrationStatus{Migration: Migration{Version: version}, AppliedAt: appliedAt})
+		}
+		return nil
+	})
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, err
+}
+
+// locked runs fn with the applied migrations while holding the migration lock on a dedicated connection.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, applied map[int64]time.Time) error) error {
+	conn, err := m.db.sql.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("migration connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.GetLogger().Warn(ctx, fmt.Sprintf(logStr, "Close migration connection"), zap.Error(err))
+		}
+	}()
+
+	log.GetLogger().Info(ctx, fmt.Sprintf(logStr, "Waiting migration lock"))
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", MigrationLockKey); err != nil {
+		return fmt.Errorf("migration lock: %w", err)
+	}
+	defer func() {
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if _, err := conn.ExecContext(unlockCtx, "SELECT pg_advisory_unlock($1)", MigrationLockKey); err != nil {
+			log.GetLogger().Warn(ctx, fmt.Sprintf(logStr, "Migration unlock"), zap.Error(err))
+		}
+	}()
+
+	if _, err = conn.ExecContext(ctx, createMigrationsTable); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	start := time.Now()
+	logger := log.GetLogger().With(zap.Int64("version", migration.Version), zap.String("name", migration.Name))
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("migration %d_%s: begin: %w", migration.Version, migration.Name, err)
+	}
+	defer func() {
+		// Rollback after commit is a no-op.
+		_ = tx.Rollback()
+	}()
+
+	if up {
+		_, err = tx.ExecContext(ctx, migration.Up)
+	} else {
+		_, err = tx.ExecContext(ctx, migration.Down)
+	}
+	if err != nil {
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("migration %d_%s: record: %w", migration.Version, migration.Name, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("migration %d_%s: commit: %w", migration.Version, migration.Name, err)
+	}
+
+	if up {
+		logger.Info(ctx, fmt.Sprintf(logStr, "Migration applied"), zap.Duration("duration", time.Since(start)))
+	} else {
+		logger.Info(ctx, fmt.Sprintf(logStr, "Migration reverted"), zap.Duration("duration", time.Since(start)))
+	}
+
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("read schema_migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
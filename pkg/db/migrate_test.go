@@ -0,0 +1,106 @@
+//go:build unit
+// +build unit
+
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	type want struct {
+		migrations []Migration
+		err        bool
+	}
+
+	testCases := []struct {
+		name string
+		args fstest.MapFS
+		want want
+	}{
+		{
+			name: "Empty",
+			args: fstest.MapFS{},
+			want: want{migrations: []Migration{}},
+		},
+		{
+			name: "Sorted",
+			args: fstest.MapFS{
+				"0010_add_name.up.sql":        {Data: []byte("ALTER TABLE example ADD name text;")},
+				"0010_add_name.down.sql":      {Data: []byte("ALTER TABLE example DROP name;")},
+				"0002_create_example.up.sql":  {Data: []byte("CREATE TABLE example (id bigint);")},
+				"README.md":                   {Data: []byte("migrations")},
+				"0003_backfill/0003.up.sql":   {Data: []byte("SELECT 1;")},
+				"0004_backfill.sql":           {Data: []byte("SELECT 1;")},
+				"0005_backfill.upgrade.sql":   {Data: []byte("SELECT 1;")},
+				"0002_create_example.unknown": {Data: []byte("SELECT 1;")},
+			},
+			want: want{migrations: []Migration{
+				{Version: 2, Name: "create_example", Up: "CREATE TABLE example (id bigint);"},
+				{Version: 10, Name: "add_name", Up: "ALTER TABLE example ADD name text;", Down: "ALTER TABLE example DROP name;"},
+			}},
+		},
+		{
+			name: "NoUp",
+			args: fstest.MapFS{
+				"0001_create_example.down.sql": {Data: []byte("DROP TABLE example;")},
+			},
+			want: want{err: true},
+		},
+		{
+			name: "DuplicateVersion",
+			args: fstest.MapFS{
+				"0001_create_example.up.sql": {Data: []byte("CREATE TABLE example (id bigint);")},
+				"0001_create_players.up.sql": {Data: []byte("CREATE TABLE players (id bigint);")},
+			},
+			want: want{err: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			migrations, err := LoadMigrations(tc.args)
+			if tc.want.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want.migrations, migrations)
+		})
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+
+	paths, err := CreateMigration(dir, "Create players")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "0001_create_players.up.sql"),
+		filepath.Join(dir, "0001_create_players.down.sql"),
+	}, paths)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "0007_add_rank.up.sql"), []byte("SELECT 1;"), 0o644))
+
+	paths, err = CreateMigration(dir, "add-player-rank")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "0008_add_player_rank.up.sql"),
+		filepath.Join(dir, "0008_add_player_rank.down.sql"),
+	}, paths)
+
+	migrations, err := LoadMigrations(os.DirFS(dir))
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 3)
+
+	_, err = CreateMigration(dir, "drop players;")
+	assert.Error(t, err)
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	_ "github.com/jackc/pgx/v4/stdlib" // registers the pgx driver for the replicas
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/redrru/fantasy-dota/pkg/log"
+)
+
+const defaultReplicaHealthInterval = 10 * time.Second
+
+var replicaUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "db",
+	Name:      "replica_up",
+	Help:      "1 if the read replica passed the last health check.",
+}, []string{"replica"})
+
+type forcePrimaryKey struct{}
+
+// ForcePrimary makes the queries with the returned context read from the primary,
+// e.g. to read the rows written by the same request which may not have reached the replicas yet.
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey{}, true)
+}
+
+// IsForcePrimary reports whether the queries with ctx read from the primary, see ForcePrimary.
+func IsForcePrimary(ctx context.Context) bool {
+	force, _ := ctx.Value(forcePrimaryKey{}).(bool)
+	return force
+}
+
+type replica struct {
+	name    string
+	sql     *sql.DB
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// setHealthy returns true if the health of the replica changed.
+func (r *replica) setHealthy(healthy bool) bool {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	replicaUp.WithLabelValues(r.name).Set(float64(v))
+	return atomic.SwapInt32(&r.healthy, v) != v
+}
+
+// replicas routes the read queries outside of transactions to the healthy replicas in round robin.
+type replicas struct {
+	primary  *sql.DB
+	replicas []*replica
+	next     uint32
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newReplicas(primary *sql.DB, config Config) (*replicas, error) {
+	rs := &replicas{primary: primary}
+
+	for _, dsn := range config.ReplicaDSNs {
+		pgConfig, err := pgx.ParseConfig(dsn)
+		if err != nil {
+			_ = rs.close()
+			return nil, fmt.Errorf("parse replica DSN failed: %w", err)
+		}
+
+		sqlDB, err := sql.Open("pgx", dsn)
+		if err != nil {
+			_ = rs.close()
+			return nil, fmt.Errorf("open replica failed: %w", err)
+		}
+		setPool(sqlDB, config)
+
+		// Replicas are healthy until the first check fails, so that reads are spread right after the start.
+		r := &replica{name: fmt.Sprintf("%s:%d", pgConfig.Host, pgConfig.Port), sql: sqlDB}
+		r.setHealthy(true)
+		registerPool("replica "+r.name, sqlDB)
+		rs.replicas = append(rs.replicas, r)
+	}
+
+	interval := config.ReplicaHealthInterval
+	if interval <= 0 {
+		interval = defaultReplicaHealthInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rs.cancel = cancel
+	rs.wg.Add(1)
+	go func() {
+		defer rs.wg.Done()
+		rs.checkHealth(ctx, interval)
+	}()
+
+	return rs, nil
+}
+
+// register routes the reads of orm to the replicas.
+func (rs *replicas) register(orm *gorm.DB) error {
+	if err := orm.Callback().Query().Before("gorm:query").Register("db:replica", rs.route); err != nil {
+		return err
+	}
+	return orm.Callback().Row().Before("gorm:row").Register("db:replica", rs.route)
+}
+
+// route switches a query to a replica unless it runs in a transaction, locks rows,
+// is a raw statement other than SELECT or the primary is forced by the context.
+func (rs *replicas) route(tx *gorm.DB) {
+	if tx.Statement.ConnPool != rs.primary || IsForcePrimary(tx.Statement.Context) {
+		return
+	}
+	if _, ok := tx.Statement.Clauses["FOR"]; ok {
+		return
+	}
+	if raw := strings.TrimSpace(tx.Statement.SQL.String()); raw != "" && !strings.HasPrefix(strings.ToUpper(raw), "SELECT") {
+		return
+	}
+
+	if r := rs.pick(); r != nil {
+		tx.Statement.ConnPool = r.sql
+	}
+}
+
+// pick returns the next healthy replica, nil if there is none and the primary has to serve the read.
+func (rs *replicas) pick() *replica {
+	n := uint32(len(rs.replicas))
+	start := atomic.AddUint32(&rs.next, 1)
+
+	for i := uint32(0); i < n; i++ {
+		if r := rs.replicas[(start+i)%n]; r.isHealthy() {
+			return r
+		}
+	}
+	return nil
+}
+
+func (rs *replicas) checkHealth(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, r := range rs.replicas {
+			pingCtx, cancel := context.WithTimeout(ctx, interval)
+			err := r.sql.PingContext(pingCtx)
+			cancel()
+
+			if !r.setHealthy(err == nil) {
+				continue
+			}
+			if err != nil {
+				log.GetLogger().Warn(ctx, fmt.Sprintf(logStr, "Replica down"), zap.String("replica", r.name), zap.Error(err))
+			} else {
+				log.GetLogger().Info(ctx, fmt.Sprintf(logStr, "Replica up"), zap.String("replica", r.name))
+			}
+		}
+	}
+}
+
+func (rs *replicas) close() error {
+	if rs.cancel != nil {
+		rs.cancel()
+		rs.wg.Wait()
+	}
+
+	var err error
+	for _, r := range rs.replicas {
+		if closeErr := r.sql.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
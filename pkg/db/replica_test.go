@@ -0,0 +1,110 @@
+//go:build unit
+// +build unit
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+func TestReplicaRouting(t *testing.T) {
+	open := func() *sql.DB {
+		sqlDB, err := sql.Open("pgx", "host=localhost port=1 dbname=test")
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = sqlDB.Close() })
+		return sqlDB
+	}
+
+	primary := open()
+	orm, err := gorm.Open(postgres.New(postgres.Config{Conn: primary}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, Logger: logger.Discard})
+	assert.NoError(t, err)
+
+	rs := &replicas{
+		primary:  primary,
+		replicas: []*replica{{name: "replica1", sql: open(), healthy: 1}, {name: "replica2", sql: open(), healthy: 1}},
+	}
+	assert.NoError(t, rs.register(orm))
+
+	var routed gorm.ConnPool
+	capture := func(tx *gorm.DB) { routed = tx.Statement.ConnPool }
+	assert.NoError(t, orm.Callback().Query().After("db:replica").Register("test:capture", capture))
+	assert.NoError(t, orm.Callback().Row().After("db:replica").Register("test:capture", capture))
+
+	type model struct {
+		ID int
+	}
+
+	testCases := []struct {
+		name  string
+		query func(ctx context.Context, db *gorm.DB)
+		want  gorm.ConnPool
+	}{
+		{
+			name:  "Find",
+			query: func(ctx context.Context, db *gorm.DB) { db.WithContext(ctx).Find(&[]model{}) },
+			want:  rs.replicas[1].sql,
+		},
+		{
+			name:  "RoundRobin",
+			query: func(ctx context.Context, db *gorm.DB) { db.WithContext(ctx).Take(&model{}) },
+			want:  rs.replicas[0].sql,
+		},
+		{
+			name: "RawSelect",
+			query: func(ctx context.Context, db *gorm.DB) {
+				db.WithContext(ctx).Raw("select id from models").Scan(&[]model{})
+			},
+			want: rs.replicas[1].sql,
+		},
+		{
+			name: "RawUpdate",
+			query: func(ctx context.Context, db *gorm.DB) {
+				db.WithContext(ctx).Raw("UPDATE models SET id = 1 RETURNING id").Scan(&[]model{})
+			},
+			want: primary,
+		},
+		{
+			name: "Locking",
+			query: func(ctx context.Context, db *gorm.DB) {
+				db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Find(&[]model{})
+			},
+			want: primary,
+		},
+		{
+			name:  "ForcePrimary",
+			query: func(ctx context.Context, db *gorm.DB) { db.WithContext(ForcePrimary(ctx)).Find(&[]model{}) },
+			want:  primary,
+		},
+	}
+
+	// Not parallel, the cases depend on the round robin order.
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			routed = nil
+			tc.query(context.Background(), orm)
+			assert.True(t, routed == tc.want)
+		})
+	}
+
+	t.Run("Unhealthy", func(t *testing.T) {
+		rs.replicas[0].setHealthy(false)
+		rs.replicas[1].setHealthy(false)
+
+		orm.Find(&[]model{})
+		assert.True(t, routed == primary)
+
+		rs.replicas[0].setHealthy(true)
+		for i := 0; i < 2; i++ {
+			orm.Find(&[]model{})
+			assert.True(t, routed == rs.replicas[0].sql)
+		}
+	})
+}
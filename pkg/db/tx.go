@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/redrru/fantasy-dota/pkg/log"
+	"github.com/redrru/fantasy-dota/pkg/tracing"
+)
+
+const (
+	defaultTxMaxRetries = 3
+	txRetryBaseDelay    = 10 * time.Millisecond
+
+	// Postgres error codes of the transactions worth retrying.
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+type txKey struct{}
+
+type txOptions struct {
+	sql        sql.TxOptions
+	maxRetries int
+}
+
+// TxOption configures a transaction started by WithinTx.
+type TxOption func(o *txOptions)
+
+// WithIsolation sets the isolation level of the transaction, the default is read committed.
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(o *txOptions) {
+		o.sql.Isolation = level
+	}
+}
+
+// WithReadOnly starts a read only transaction.
+func WithReadOnly() TxOption {
+	return func(o *txOptions) {
+		o.sql.ReadOnly = true
+	}
+}
+
+// WithMaxRetries sets how many times the transaction is retried after a serialization
+// failure or a deadlock, 3 by default, 0 disables retries.
+func WithMaxRetries(n int) TxOption {
+	return func(o *txOptions) {
+		o.maxRetries = n
+	}
+}
+
+// Conn returns the transaction of ctx started by WithinTx or the DB outside of transactions.
+// Repositories use it instead of Gorm.WithContext to take part in the transactions of their callers.
+func (db *DB) Conn(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.Gorm.WithContext(ctx)
+}
+
+// WithinTx runs fn in a transaction passed in the context given to fn, it is committed if fn returns nil
+// and rolled back otherwise. WithinTx in a transaction creates a savepoint, so an error of the nested fn
+// only rolls back its own changes. The options apply to the outermost transaction only, which is retried
+// with fn on serialization failures and deadlocks, so fn must not have side effects outside the DB.
+func (db *DB) WithinTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return db.transaction(ctx, fn, nil)
+	}
+
+	options := txOptions{maxRetries: defaultTxMaxRetries}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	ctx, span := tracing.DefaultTracer().Start(ctx, "WithinTx")
+	defer span.End()
+
+	for attempt := 0; ; attempt++ {
+		err := db.transaction(ctx, fn, &options.sql)
+		if err == nil || attempt >= options.maxRetries || !IsRetryable(err) {
+			return err
+		}
+
+		delay := txRetryBaseDelay << attempt
+		delay += time.Duration(rand.Int63n(int64(delay)))
+
+		span.AddEvent("transaction retry")
+		log.GetLogger().Warn(ctx, fmt.Sprintf(logStr, "Retry transaction"),
+			zap.Int("attempt", attempt+1), zap.Duration("delay", delay), zap.Error(err))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// transaction begins a transaction or a savepoint in the transaction of ctx.
+func (db *DB) transaction(ctx context.Context, fn func(ctx context.Context) error, opts *sql.TxOptions) error {
+	run := func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	}
+
+	if opts == nil {
+		return db.Conn(ctx).Transaction(run)
+	}
+	return db.Conn(ctx).Transaction(run, opts)
+}
+
+// IsRetryable reports whether err is a serialization failure or a deadlock after which the transaction can be retried.
+func IsRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == serializationFailure || pgErr.Code == deadlockDetected
+}
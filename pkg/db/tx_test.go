@@ -0,0 +1,293 @@
+//go:build unit
+// +build unit
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// stubPool records the statements and the transaction commands sent to the connection pool.
+type stubPool struct {
+	mu         sync.Mutex
+	statements []string
+}
+
+func (p *stubPool) record(statement string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Savepoint names are generated from pointers.
+	if i := strings.Index(statement, "SAVEPOINT "); i >= 0 {
+		statement = statement[:i+len("SAVEPOINT")]
+	}
+	p.statements = append(p.statements, statement)
+}
+
+func (p *stubPool) log() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.statements...)
+}
+
+func (p *stubPool) PrepareContext(context.Context, string) (*sql.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+
+func (p *stubPool) ExecContext(_ context.Context, query string, _ ...interface{}) (sql.Result, error) {
+	p.record(query)
+	return driver.RowsAffected(1), nil
+}
+
+func (p *stubPool) QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error) {
+	return nil, errors.New("query is not supported")
+}
+
+func (p *stubPool) QueryRowContext(context.Context, string, ...interface{}) *sql.Row {
+	return nil
+}
+
+func (p *stubPool) BeginTx(_ context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	p.record(fmt.Sprintf("BEGIN %s read_only=%t", opts.Isolation, opts.ReadOnly))
+	return &stubTx{stubPool: p}, nil
+}
+
+type stubTx struct {
+	*stubPool
+}
+
+func (tx *stubTx) Commit() error {
+	tx.record("COMMIT")
+	return nil
+}
+
+func (tx *stubTx) Rollback() error {
+	tx.record("ROLLBACK")
+	return nil
+}
+
+func newStubDB(t *testing.T) (*DB, *stubPool) {
+	pool := &stubPool{}
+	orm, err := gorm.Open(postgres.New(postgres.Config{Conn: pool}), &gorm.Config{
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	assert.NoError(t, err)
+
+	return &DB{Gorm: orm}, pool
+}
+
+func TestWithinTxConn(t *testing.T) {
+	db, pool := newStubDB(t)
+	ctx := context.Background()
+
+	assert.Same(t, pool, db.Conn(ctx).Statement.ConnPool, "outside of transactions Conn is the pool")
+
+	err := db.WithinTx(ctx, func(ctx context.Context) error {
+		tx, ok := db.Conn(ctx).Statement.ConnPool.(*stubTx)
+		assert.True(t, ok, "Conn must return the transaction of ctx")
+		assert.Same(t, pool, tx.stubPool)
+
+		return db.Conn(ctx).Exec("INSERT INTO picks VALUES (1)").Error
+	}, WithIsolation(sql.LevelSerializable), WithReadOnly())
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"BEGIN Serializable read_only=true", "INSERT INTO picks VALUES (1)", "COMMIT"}, pool.log())
+}
+
+func TestWithinTxSavepoint(t *testing.T) {
+	db, pool := newStubDB(t)
+	errInner := errors.New("inner failed")
+
+	err := db.WithinTx(context.Background(), func(ctx context.Context) error {
+		if err := db.Conn(ctx).Exec("INSERT INTO picks VALUES (1)").Error; err != nil {
+			return err
+		}
+
+		err := db.WithinTx(ctx, func(ctx context.Context) error {
+			db.Conn(ctx).Exec("INSERT INTO picks VALUES (2)")
+			return errInner
+		}, WithIsolation(sql.LevelSerializable))
+		assert.ErrorIs(t, err, errInner)
+
+		return db.Conn(ctx).Exec("INSERT INTO picks VALUES (3)").Error
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"BEGIN Default read_only=false",
+		"INSERT INTO picks VALUES (1)",
+		"SAVEPOINT",
+		"INSERT INTO picks VALUES (2)",
+		"ROLLBACK TO SAVEPOINT",
+		"INSERT INTO picks VALUES (3)",
+		"COMMIT",
+	}, pool.log(), "only the nested block is rolled back and the options of nested calls are ignored")
+}
+
+func TestWithinTxRetry(t *testing.T) {
+	serializationErr := &pgconn.PgError{Code: serializationFailure}
+
+	testCases := []struct {
+		name      string
+		opts      []TxOption
+		failures  int
+		err       error
+		wantErr   bool
+		wantCalls int
+	}{
+		{
+			name:      "Retried",
+			failures:  2,
+			err:       serializationErr,
+			wantCalls: 3,
+		},
+		{
+			name:      "Deadlock",
+			failures:  1,
+			err:       fmt.Errorf("submit pick: %w", &pgconn.PgError{Code: deadlockDetected}),
+			wantCalls: 2,
+		},
+		{
+			name:      "AttemptLimit",
+			opts:      []TxOption{WithMaxRetries(2)},
+			failures:  5,
+			err:       serializationErr,
+			wantErr:   true,
+			wantCalls: 3,
+		},
+		{
+			name:      "RetriesDisabled",
+			opts:      []TxOption{WithMaxRetries(0)},
+			failures:  1,
+			err:       serializationErr,
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name:      "NotRetryable",
+			failures:  1,
+			err:       &pgconn.PgError{Code: "23505"},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, pool := newStubDB(t)
+
+			var calls int
+			err := db.WithinTx(context.Background(), func(ctx context.Context) error {
+				calls++
+				if calls <= tc.failures {
+					return tc.err
+				}
+				return nil
+			}, tc.opts...)
+
+			if tc.wantErr {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantCalls, calls)
+
+			var begins int
+			for _, statement := range pool.log() {
+				if strings.HasPrefix(statement, "BEGIN") {
+					begins++
+				}
+			}
+			assert.Equal(t, tc.wantCalls, begins, "every attempt runs in a new transaction")
+		})
+	}
+}
+
+func TestWithinTxNestedNoRetry(t *testing.T) {
+	db, pool := newStubDB(t)
+
+	var outer, nested int
+	err := db.WithinTx(context.Background(), func(ctx context.Context) error {
+		outer++
+
+		// The nested block is not retried on its own, the whole transaction is.
+		return db.WithinTx(ctx, func(ctx context.Context) error {
+			nested++
+			if outer == 1 {
+				return &pgconn.PgError{Code: serializationFailure}
+			}
+			return nil
+		})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, outer)
+	assert.Equal(t, 2, nested)
+
+	assert.Equal(t, []string{
+		"BEGIN Default read_only=false",
+		"SAVEPOINT",
+		"ROLLBACK TO SAVEPOINT",
+		"ROLLBACK",
+		"BEGIN Default read_only=false",
+		"SAVEPOINT",
+		"COMMIT",
+	}, pool.log())
+}
+
+func TestIsRetryable(t *testing.T) {
+	testCases := []struct {
+		name string
+		args error
+		want bool
+	}{
+		{
+			name: "Nil",
+		},
+		{
+			name: "Other",
+			args: errors.New("connection refused"),
+		},
+		{
+			name: "UniqueViolation",
+			args: &pgconn.PgError{Code: "23505"},
+		},
+		{
+			name: "SerializationFailure",
+			args: &pgconn.PgError{Code: "40001"},
+			want: true,
+		},
+		{
+			name: "Deadlock",
+			args: fmt.Errorf("submit pick: %w", &pgconn.PgError{Code: "40P01"}),
+			want: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, IsRetryable(tc.args))
+		})
+	}
+}
@@ -2,6 +2,7 @@ package env
 
 import (
 	"context"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -34,6 +35,9 @@ func (e *Env) GetDuration(k string) time.Duration {
 var (
 	env  = Env{store: map[string]string{}}
 	once sync.Once
+
+	// secretNames mark the variables whose values are not logged, URLs with credentials are not logged either.
+	secretNames = []string{"AUTH", "DSN", "DATA_SOURCE", "PASSWORD", "SECRET", "TOKEN", "API_KEY"}
 )
 
 func GetEnv() Env {
@@ -46,8 +50,33 @@ func GetEnv() Env {
 			env.store[split[0]] = split[1]
 		}
 
-		log.GetLogger().Info(context.Background(), "[Env] GetEnv", zap.Any("store", env.store))
+		log.GetLogger().Info(context.Background(), "[Env] GetEnv", zap.Any("store", env.redacted()))
 	})
 
 	return env
 }
+
+// redacted returns the store with the values of secret variables replaced.
+func (e *Env) redacted() map[string]string {
+	store := make(map[string]string, len(e.store))
+	for k, v := range e.store {
+		if v != "" && isSecret(k, v) {
+			store[k] = "REDACTED"
+		} else {
+			store[k] = v
+		}
+	}
+
+	return store
+}
+
+func isSecret(k, v string) bool {
+	for _, name := range secretNames {
+		if strings.Contains(k, name) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(v)
+	return err == nil && u.User != nil
+}
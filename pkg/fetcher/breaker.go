@@ -0,0 +1,257 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/redrru/fantasy-dota/pkg/http"
+	"github.com/redrru/fantasy-dota/pkg/log"
+)
+
+const (
+	defaultBreakerOpenTimeout      = 30 * time.Second
+	defaultBreakerHalfOpenRequests = 1
+
+	hostLabel = "host"
+)
+
+// ErrCircuitOpen is returned instead of fetching while the circuit breaker of the upstream host is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed requests to a host that opens its breaker, 0 disables breakers.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before a probe request is let through.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of successful probes in a row that close the breaker.
+	HalfOpenRequests int
+}
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+var (
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "breaker_state",
+		Help:      "Circuit breaker state of an upstream host: 0 closed, 1 half-open, 2 open.",
+	}, []string{hostLabel})
+
+	breakerTripsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "breaker_trips_total",
+		Help:      "Number of times the circuit breaker of an upstream host opened.",
+	}, []string{hostLabel})
+
+	breakerRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "breaker_rejected_total",
+		Help:      "Number of fetches short-circuited by an open breaker.",
+	}, []string{hostLabel})
+)
+
+// breaker is a circuit breaker of a single upstream host.
+type breaker struct {
+	host   string
+	config BreakerConfig
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int
+	probing   bool
+	openedAt  time.Time
+}
+
+func newBreaker(host string, config BreakerConfig) *breaker {
+	breakerState.WithLabelValues(host).Set(float64(BreakerClosed))
+
+	return &breaker{host: host, config: config}
+}
+
+// allow reports whether a request may be sent. An open breaker lets a single probe through once OpenTimeout passes.
+func (b *breaker) allow(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.config.OpenTimeout {
+			break
+		}
+		b.setState(BreakerHalfOpen)
+		b.successes = 0
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			break
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+
+	breakerRejectedTotal.WithLabelValues(b.host).Inc()
+	return fmt.Errorf("%s: %w", b.host, ErrCircuitOpen)
+}
+
+// done records the result of an allowed request and reports whether the breaker has just opened.
+func (b *breaker) done(now time.Time, failed bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Requests sent before the breaker opened do not extend the outage.
+	if b.state == BreakerOpen {
+		return false
+	}
+
+	halfOpen := b.state == BreakerHalfOpen
+	if halfOpen {
+		b.probing = false
+	}
+
+	if !failed {
+		b.failures = 0
+		if halfOpen {
+			if b.successes++; b.successes >= b.config.HalfOpenRequests {
+				b.setState(BreakerClosed)
+			}
+		}
+		return false
+	}
+
+	b.failures++
+	if !halfOpen && b.failures < b.config.FailureThreshold {
+		return false
+	}
+
+	b.setState(BreakerOpen)
+	b.openedAt = now
+	breakerTripsTotal.WithLabelValues(b.host).Inc()
+	return true
+}
+
+// abort releases the probe slot of a request that ended without a result.
+func (b *breaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+	}
+}
+
+func (b *breaker) setState(state BreakerState) {
+	b.state = state
+	breakerState.WithLabelValues(b.host).Set(float64(state))
+}
+
+func (b *breaker) getState() (BreakerState, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state, b.failures
+}
+
+// BreakerStates returns the state of the circuit breaker of every upstream host fetched so far.
+func (f *Fetcher) BreakerStates() map[string]BreakerState {
+	f.breakersMu.Lock()
+	defer f.breakersMu.Unlock()
+
+	states := make(map[string]BreakerState, len(f.breakers))
+	for host, b := range f.breakers {
+		states[host], _ = b.getState()
+	}
+
+	return states
+}
+
+// getBreaker returns the breaker of the host, nil if breakers are disabled.
+func (f *Fetcher) getBreaker(host string) *breaker {
+	if f.config.Breaker.FailureThreshold <= 0 {
+		return nil
+	}
+
+	f.breakersMu.Lock()
+	defer f.breakersMu.Unlock()
+
+	b, ok := f.breakers[host]
+	if !ok {
+		b = newBreaker(host, f.config.Breaker)
+		f.breakers[host] = b
+	}
+
+	return b
+}
+
+// withBreaker short-circuits fetch while the breaker of the host is open and feeds it the fetch result.
+// Only upstream failures count, a fetch ended by ctx or held back by the client rate limiter is not counted at all.
+func (f *Fetcher) withBreaker(ctx context.Context, host string, logger log.Logger, fetch func() error) error {
+	b := f.getBreaker(host)
+	if b == nil {
+		return fetch()
+	}
+
+	if err := b.allow(time.Now()); err != nil {
+		return err
+	}
+
+	err := fetch()
+	if err != nil && (ctx.Err() != nil || errors.Is(err, http.ErrRateLimited)) {
+		b.abort()
+		return err
+	}
+
+	if b.done(time.Now(), err != nil && isUpstreamFailure(err)) {
+		_, failures := b.getState()
+		trace.SpanFromContext(ctx).AddEvent("circuit breaker open", trace.WithAttributes(
+			attribute.String("host", host),
+			attribute.Int("failures", failures),
+			attribute.String("error", err.Error()),
+		))
+		logger.Warn(ctx, "[Fetcher] Circuit breaker open", zap.String("host", host), zap.Duration("timeout", b.config.OpenTimeout), zap.Error(err))
+	}
+
+	return err
+}
+
+// isUpstreamFailure reports whether err is a transport error or a 429/5xx response. Other errors,
+// e.g. of building the request or of reading a too large body, say nothing about the upstream health.
+func isUpstreamFailure(err error) bool {
+	if status := http.StatusCode(err); status != 0 {
+		return status == nethttp.StatusTooManyRequests || status >= nethttp.StatusInternalServerError
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
@@ -0,0 +1,206 @@
+//go:build unit
+// +build unit
+
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	fetcherhttp "github.com/redrru/fantasy-dota/pkg/http"
+	"github.com/redrru/fantasy-dota/pkg/log"
+)
+
+func TestBreaker(t *testing.T) {
+	type step struct {
+		at      time.Duration
+		failed  bool
+		allowed bool
+		state   BreakerState
+	}
+
+	testCases := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "OpensAfterThreshold",
+			steps: []step{
+				{at: 0, failed: true, allowed: true, state: BreakerClosed},
+				{at: 0, failed: true, allowed: true, state: BreakerOpen},
+				{at: time.Second, allowed: false, state: BreakerOpen},
+			},
+		},
+		{
+			name: "SuccessResetsFailures",
+			steps: []step{
+				{at: 0, failed: true, allowed: true, state: BreakerClosed},
+				{at: 0, failed: false, allowed: true, state: BreakerClosed},
+				{at: 0, failed: true, allowed: true, state: BreakerClosed},
+			},
+		},
+		{
+			name: "ClosesAfterProbes",
+			steps: []step{
+				{at: 0, failed: true, allowed: true, state: BreakerClosed},
+				{at: 0, failed: true, allowed: true, state: BreakerOpen},
+				{at: time.Minute, failed: false, allowed: true, state: BreakerHalfOpen},
+				{at: time.Minute, failed: false, allowed: true, state: BreakerClosed},
+			},
+		},
+		{
+			name: "ReopensOnFailedProbe",
+			steps: []step{
+				{at: 0, failed: true, allowed: true, state: BreakerClosed},
+				{at: 0, failed: true, allowed: true, state: BreakerOpen},
+				{at: time.Minute, failed: true, allowed: true, state: BreakerOpen},
+				{at: time.Minute + time.Second, allowed: false, state: BreakerOpen},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			b := newBreaker("breaker-"+tc.name, BreakerConfig{FailureThreshold: 2, OpenTimeout: 30 * time.Second, HalfOpenRequests: 2})
+			start := time.Now()
+
+			for i, s := range tc.steps {
+				err := b.allow(start.Add(s.at))
+				assert.Equal(t, s.allowed, err == nil, "step %d", i)
+				if err == nil {
+					b.done(start.Add(s.at), s.failed)
+				} else {
+					assert.ErrorIs(t, err, ErrCircuitOpen)
+				}
+
+				state, _ := b.getState()
+				assert.Equal(t, s.state, state, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	b := newBreaker("breaker-single-probe", BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Second, HalfOpenRequests: 1})
+	now := time.Now()
+
+	assert.NoError(t, b.allow(now))
+	assert.True(t, b.done(now, true))
+
+	now = now.Add(time.Second)
+	assert.NoError(t, b.allow(now))
+	assert.ErrorIs(t, b.allow(now), ErrCircuitOpen, "half-open breaker lets one probe at a time")
+
+	b.abort()
+	assert.NoError(t, b.allow(now))
+}
+
+func TestFetcherBreaker(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	assert.NoError(t, err)
+
+	first := &testHandler{url: ts.URL + "/first", refresh: 5 * time.Millisecond}
+	second := &testHandler{url: ts.URL + "/second", refresh: 5 * time.Millisecond}
+
+	store := &memoryStore{checkpoints: map[string][]byte{}}
+	f := NewFetcher(Config{Breaker: BreakerConfig{FailureThreshold: 3, OpenTimeout: time.Hour}})
+	f.SetStore(store)
+	f.RegisterHandlers(first, second)
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	assert.Eventually(t, func() bool {
+		return f.BreakerStates()[u.Host] == BreakerOpen
+	}, time.Second, 5*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(breakerRejectedTotal.WithLabelValues(u.Host)) >= 4
+	}, time.Second, 5*time.Millisecond)
+
+	// Requests of both handlers may already be in flight when the breaker trips.
+	assert.LessOrEqual(t, atomic.LoadInt32(&requests), int32(4), "open breaker must short-circuit all handlers of the host")
+	assert.Equal(t, float64(BreakerOpen), testutil.ToFloat64(breakerState.WithLabelValues(u.Host)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(breakerTripsTotal.WithLabelValues(u.Host)))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&first.calls)+atomic.LoadInt32(&second.calls))
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, run := range store.runs {
+		assert.NotEqual(t, ErrCircuitOpen.Error(), run.Error, "runs skipped by the breaker are not saved")
+	}
+	assert.LessOrEqual(t, len(store.runs), int(atomic.LoadInt32(&requests)))
+}
+
+func TestWithBreakerRateLimited(t *testing.T) {
+	f := NewFetcher(Config{Breaker: BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Hour}})
+	rateLimited := &url.Error{Op: "Get", URL: "https://rate.limited", Err: fetcherhttp.ErrRateLimited}
+
+	for i := 0; i < 3; i++ {
+		err := f.withBreaker(context.Background(), "rate.limited", log.GetLogger(), func() error {
+			return rateLimited
+		})
+		assert.ErrorIs(t, err, fetcherhttp.ErrRateLimited)
+	}
+
+	assert.Equal(t, BreakerClosed, f.BreakerStates()["rate.limited"], "requests held back by the client are not upstream failures")
+}
+
+func TestWithBreakerFailures(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	testCases := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		open bool
+	}{
+		{name: "Canceled", ctx: cancelled, err: &url.Error{Op: "Get", URL: "u", Err: context.Canceled}},
+		{name: "DeadlineExceeded", ctx: expired, err: &url.Error{Op: "Get", URL: "u", Err: context.DeadlineExceeded}},
+		{name: "StatusNotFound", ctx: context.Background(), err: &fetcherhttp.StatusError{StatusCode: http.StatusNotFound}},
+		{name: "BodyTooLarge", ctx: context.Background(), err: fetcherhttp.ErrBodyTooLarge},
+		{name: "StatusTooManyRequests", ctx: context.Background(), err: &fetcherhttp.StatusError{StatusCode: http.StatusTooManyRequests}, open: true},
+		{name: "StatusServiceUnavailable", ctx: context.Background(), err: &fetcherhttp.StatusError{StatusCode: http.StatusServiceUnavailable}, open: true},
+		{name: "Transport", ctx: context.Background(), err: &url.Error{Op: "Get", URL: "u", Err: errors.New("connection refused")}, open: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := NewFetcher(Config{Breaker: BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Hour}})
+			host := "breaker-" + tc.name
+
+			err := f.withBreaker(tc.ctx, host, log.GetLogger(), func() error { return tc.err })
+			assert.ErrorIs(t, err, tc.err)
+
+			want := BreakerClosed
+			if tc.open {
+				want = BreakerOpen
+			}
+			assert.Equal(t, want, f.BreakerStates()[host])
+		})
+	}
+}
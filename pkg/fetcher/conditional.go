@@ -0,0 +1,68 @@
+package fetcher
+
+import (
+	"context"
+
+	"github.com/redrru/fantasy-dota/pkg/http"
+)
+
+// maxValidators caps the validators kept by a handler, handlers building a new URL on every run would grow them forever.
+const maxValidators = 64
+
+// NotModifiedHandler may be implemented by a Handler to be notified when the
+// upstream answers 304 Not Modified, Handle is not called in that case.
+type NotModifiedHandler interface {
+	HandleNotModified(ctx context.Context) error
+}
+
+// validators are the validators of the last responses of a handler by request URL, the oldest URL is evicted first.
+type validators struct {
+	byURL map[string]http.Validators
+	order []string
+}
+
+func (e *entry) getValidators(url string) http.Validators {
+	e.validatorsMu.Lock()
+	defer e.validatorsMu.Unlock()
+
+	return e.validators.byURL[url]
+}
+
+func (e *entry) setValidators(url string, v http.Validators) {
+	e.validatorsMu.Lock()
+	defer e.validatorsMu.Unlock()
+
+	if _, ok := e.validators.byURL[url]; ok {
+		delete(e.validators.byURL, url)
+		e.validators.order = removeURL(e.validators.order, url)
+	}
+	if v == (http.Validators{}) {
+		return
+	}
+
+	if e.validators.byURL == nil {
+		e.validators.byURL = map[string]http.Validators{}
+	}
+	if len(e.validators.order) >= maxValidators {
+		delete(e.validators.byURL, e.validators.order[0])
+		e.validators.order = e.validators.order[1:]
+	}
+	e.validators.byURL[url] = v
+	e.validators.order = append(e.validators.order, url)
+}
+
+func (e *entry) resetValidators() {
+	e.validatorsMu.Lock()
+	defer e.validatorsMu.Unlock()
+
+	e.validators = validators{}
+}
+
+func removeURL(urls []string, url string) []string {
+	for i := range urls {
+		if urls[i] == url {
+			return append(urls[:i], urls[i+1:]...)
+		}
+	}
+	return urls
+}
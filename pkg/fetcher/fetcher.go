@@ -2,9 +2,17 @@ package fetcher
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	nethttp "net/http"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
 	"github.com/redrru/fantasy-dota/pkg/http"
@@ -12,118 +20,390 @@ import (
 	"github.com/redrru/fantasy-dota/pkg/tracing"
 )
 
+const (
+	defaultWorkers     = 4
+	defaultMaxInFlight = 1
+)
+
 type Handler interface {
 	Handle(ctx context.Context, response []byte) error
 	GetRefreshTime() time.Duration
 	GetURL() string
 }
 
+// ConcurrentHandler may be implemented by a Handler to override Config.MaxInFlight.
+type ConcurrentHandler interface {
+	GetMaxInFlight() int
+}
+
+type Config struct {
+	// Workers is the number of handlers that may run at the same time.
+	Workers int
+	// MaxInFlight is the default number of overlapping runs of a single handler.
+	MaxInFlight int
+	// MaxBodySize limits decoded response bodies, 0 means no limit.
+	MaxBodySize int64
+	// Breaker configures the circuit breakers of upstream hosts.
+	Breaker BreakerConfig
+	// HTTPClient is used to fetch handlers, a default client is created if nil.
+	HTTPClient *http.Client
+}
+
+// job is a scheduled run of an entry waiting for a worker.
+type job struct {
+	entry    *entry
+	enqueued time.Time
+	// attempt is counted from 1, retries are queued as new jobs with the next attempt.
+	attempt int
+}
+
 type Fetcher struct {
+	config     Config
 	httpClient *http.Client
-	handlers   []Handler
 
-	handler      chan Handler
-	tickersClose []chan struct{}
-	close        chan struct{}
+	mu      sync.RWMutex
+	entries map[string]*entry
+	running bool
+	closed  bool
+	standby bool
+	store   StateStore
+
+	// ctx is the parent of all runs, it is cancelled when the shutdown deadline is exceeded.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	activeMu sync.Mutex
+	active   map[string]int
+
+	breakersMu sync.Mutex
+	breakers   map[string]*breaker
+
+	queue chan job
+	close chan struct{}
+	wg    sync.WaitGroup
 }
 
-func NewFetcher() *Fetcher {
+func NewFetcher(config Config) *Fetcher {
+	if config.Workers <= 0 {
+		config.Workers = defaultWorkers
+	}
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = defaultMaxInFlight
+	}
+	if config.Breaker.OpenTimeout <= 0 {
+		config.Breaker.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if config.Breaker.HalfOpenRequests <= 0 {
+		config.Breaker.HalfOpenRequests = defaultBreakerHalfOpenRequests
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.NewClient()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
 	fetcher := &Fetcher{
-		httpClient: http.NewClient(),
+		config:     config,
+		httpClient: config.HTTPClient,
+		entries:    map[string]*entry{},
+		ctx:        ctx,
+		cancel:     cancel,
+		active:     map[string]int{},
+		breakers:   map[string]*breaker{},
+		queue:      make(chan job, config.Workers),
 		close:      make(chan struct{}),
 	}
 
 	return fetcher
 }
 
+// RegisterHandlers adds handlers, it may be called both before and after Run.
 func (f *Fetcher) RegisterHandlers(handlers ...Handler) {
-	f.handlers = append(f.handlers, handlers...)
+	for _, handler := range handlers {
+		if err := f.Add(handler); err != nil {
+			log.GetLogger().Error(context.Background(), "[Fetcher] Register handler", zap.String("url", handler.GetURL()), zap.Error(err))
+		}
+	}
 }
 
-func (f *Fetcher) Run() {
-	f.initTickers()
+// Run starts the scheduler and the workers and blocks until the fetcher is closed.
+// Cancelling ctx stops the fetcher and cancels the in-flight runs without waiting for them.
+func (f *Fetcher) Run(ctx context.Context) {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return
+	}
+	f.running = true
+	for _, e := range f.entries {
+		go f.schedule(e)
+	}
+	f.wg.Add(f.config.Workers)
+	f.mu.Unlock()
 
-	for {
+	for i := 0; i < f.config.Workers; i++ {
+		go f.worker()
+	}
+
+	go func() {
 		select {
+		case <-ctx.Done():
+			f.cancel()
+			f.stop()
 		case <-f.close:
-			return
-		case handler := <-f.handler:
-			ctx := context.Background()
-			logger := log.GetLogger().With(zap.String("url", handler.GetURL()))
-
-			f.withTracing(ctx, func(ctx context.Context) (err error) {
-				defer func() {
-					if r := recover(); r != nil {
-						if panicErr, ok := r.(error); ok {
-							err = panicErr
-							logger.Error(ctx, "[Fetcher] Fetch panic", zap.Error(panicErr))
-						}
-					}
-				}()
-
-				resp, err := f.fetch(ctx, handler)
-				if err != nil {
-					logger.Error(ctx, "[Fetcher] Fetch", zap.Error(err))
-					return err
-				}
+		}
+	}()
 
-				err = handler.Handle(ctx, resp)
-				if err != nil {
-					logger.Error(ctx, "[Fetcher] Handle", zap.Error(err))
-					return err
-				}
+	f.wg.Wait()
+}
 
-				return err
-			})
+// SetActive switches the fetcher between active and standby modes. In standby scheduled runs are
+// skipped, it is used to run handlers on a single replica only. Interval handlers that are not paused
+// run immediately on activation.
+func (f *Fetcher) SetActive(active bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.standby == !active {
+		return
+	}
+	f.standby = !active
+
+	if active {
+		for _, e := range f.entries {
+			if e.isInterval() && !e.isPaused() {
+				e.notify(e.trigger)
+			}
 		}
 	}
+
+	log.GetLogger().Info(context.Background(), "[Fetcher] Set active", zap.Bool("active", active))
 }
 
-func (f *Fetcher) Close() error {
-	f.close <- struct{}{}
+func (f *Fetcher) isActive() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 
-	for _, ch := range f.tickersClose {
-		ch <- struct{}{}
-	}
+	return !f.standby
+}
 
-	log.GetLogger().Debug(context.Background(), "[Fetcher] Exited")
+// enqueue passes the entry to the workers unless it is already at its in-flight limit.
+// It returns false once the fetcher is closed or the entry is removed.
+func (f *Fetcher) enqueue(e *entry) bool {
+	if !e.acquire() {
+		log.GetLogger().Debug(context.Background(), "[Fetcher] Skip tick, handler is busy", zap.String("handler", e.name))
+		return true
+	}
 
-	return nil
+	return f.submit(job{entry: e, enqueued: time.Now(), attempt: 1})
 }
 
-func (f *Fetcher) initTickers() {
-	if f.handler == nil {
-		f.handler = make(chan Handler, len(f.handlers))
+// submit passes a job holding an in-flight slot to the workers, the slot is released if the fetcher
+// is closed or the entry is removed first.
+func (f *Fetcher) submit(j job) bool {
+	select {
+	case <-f.close:
+		j.entry.release()
+		return false
+	case <-j.entry.stop:
+		j.entry.release()
+		return false
+	case f.queue <- j:
+		return true
 	}
+}
 
-	for _, handler := range f.handlers {
-		handler := handler
-		f.handler <- handler
-
-		stop := make(chan struct{})
-		f.tickersClose = append(f.tickersClose, stop)
+// retryAfter queues the next attempt of j after backoff. The worker is free during the backoff,
+// the entry keeps its in-flight slot, so scheduled runs do not overlap with the retry.
+func (f *Fetcher) retryAfter(j job, backoff time.Duration) {
+	time.AfterFunc(backoff, func() {
+		f.submit(job{entry: j.entry, enqueued: time.Now(), attempt: j.attempt + 1})
+	})
+}
 
-		go func() {
-			ticker := time.NewTicker(handler.GetRefreshTime())
-			defer ticker.Stop()
+func (f *Fetcher) worker() {
+	defer f.wg.Done()
 
-			for {
-				select {
-				case <-stop:
-					return
-				case <-ticker.C:
-					f.handler <- handler
+	for {
+		select {
+		case <-f.close:
+			return
+		case j := <-f.queue:
+			if !j.entry.stopped() && !f.isClosed() {
+				queueWait.WithLabelValues(j.entry.name).Observe(time.Since(j.enqueued).Seconds())
+				if f.process(j) {
+					// The retry holds the in-flight slot.
+					continue
 				}
 			}
-		}()
+			j.entry.release()
+		}
 	}
 }
 
-func (f *Fetcher) fetch(ctx context.Context, handler Handler) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+// process runs an attempt of the job, it returns true if a retry is scheduled.
+func (f *Fetcher) process(j job) (retrying bool) {
+	e := j.entry
+	ctx := f.ctx
+	f.begin(e.name)
+	defer f.end(e.name)
+
+	logger := log.GetLogger().With(zap.String("handler", e.name), zap.String("url", e.handler.GetURL()))
+	policy := retryPolicy(e.impl)
+
+	f.withTracing(ctx, func(ctx context.Context) error {
+		err := f.run(ctx, e, logger)
+		if !policy.shouldRetry(j.attempt, err) || ctx.Err() != nil {
+			return err
+		}
+
+		backoff := policy.backoff(j.attempt)
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", j.attempt),
+			attribute.String("backoff", backoff.String()),
+			attribute.String("error", err.Error()),
+		))
+		logger.Warn(ctx, "[Fetcher] Retry", zap.Int("attempt", j.attempt), zap.Duration("backoff", backoff))
+
+		f.retryAfter(j, backoff)
+		retrying = true
+
+		return err
+	})
+
+	return retrying
+}
+
+func (f *Fetcher) run(ctx context.Context, e *entry, logger log.Logger) (err error) {
+	started := time.Now()
+	url := e.handler.GetURL()
+
+	defer func() {
+		if r := recover(); r != nil {
+			if panicErr, ok := r.(error); ok {
+				err = panicErr
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+			panicsTotal.WithLabelValues(e.name).Inc()
+			logger.Error(ctx, "[Fetcher] Fetch panic", zap.Error(err))
+		}
+
+		f.saveRun(ctx, e, e.finishRun(started, url, err))
+	}()
+
+	ctx, err = f.withCheckpoint(ctx, e)
+	if err != nil {
+		logger.Error(ctx, "[Fetcher] Load checkpoint", zap.Error(err))
+		return err
+	}
+
+	req, err := f.buildRequest(ctx, e)
+	if err != nil {
+		logger.Error(ctx, "[Fetcher] Build request", zap.Error(err))
+		return err
+	}
+	url = req.URL.String()
+
+	runsTotal.WithLabelValues(e.name).Inc()
+
+	// The timeout covers reading the body, so a stream handler has to be done with it in time.
+	fetchCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
-	return f.httpClient.Get(ctx, handler.GetURL())
+	var (
+		body       io.ReadCloser
+		validators http.Validators
+	)
+
+	fetchStarted := time.Now()
+	err = f.withBreaker(fetchCtx, req.URL.Host, logger, func() (fetchErr error) {
+		body, validators, fetchErr = f.fetch(e, req.WithContext(fetchCtx))
+		return fetchErr
+	})
+	if errors.Is(err, ErrCircuitOpen) {
+		logger.Debug(ctx, "[Fetcher] Skip fetch, circuit breaker is open")
+		return err
+	}
+	if errors.Is(err, http.ErrNotModified) {
+		fetchDuration.WithLabelValues(e.name).Observe(time.Since(fetchStarted).Seconds())
+		trace.SpanFromContext(ctx).AddEvent("not modified")
+		logger.Debug(ctx, "[Fetcher] Not modified")
+
+		if h, ok := e.impl.(NotModifiedHandler); ok {
+			if err = h.HandleNotModified(ctx); err != nil {
+				handleErrorsTotal.WithLabelValues(e.name).Inc()
+				logger.Error(ctx, "[Fetcher] Handle not modified", zap.Error(err))
+				return err
+			}
+		}
+		if err = f.commitCheckpoint(ctx, e); err != nil {
+			logger.Error(ctx, "[Fetcher] Save checkpoint", zap.Error(err))
+		}
+		return err
+	}
+	if err != nil {
+		fetchDuration.WithLabelValues(e.name).Observe(time.Since(fetchStarted).Seconds())
+		fetchErrorsTotal.WithLabelValues(e.name).Inc()
+		logger.Error(ctx, "[Fetcher] Fetch", zap.Error(err))
+		return err
+	}
+
+	counter := &countingReader{r: body}
+	defer func() {
+		responseSize.WithLabelValues(e.name).Observe(float64(counter.n))
+		if closeErr := body.Close(); closeErr != nil {
+			logger.Warn(ctx, "[Fetcher] Close body", zap.Error(closeErr))
+		}
+	}()
+
+	if h, ok := e.impl.(StreamingHandler); ok {
+		fetchDuration.WithLabelValues(e.name).Observe(time.Since(fetchStarted).Seconds())
+		err = h.HandleStream(fetchCtx, counter)
+	} else {
+		var resp []byte
+		resp, err = ioutil.ReadAll(counter)
+		fetchDuration.WithLabelValues(e.name).Observe(time.Since(fetchStarted).Seconds())
+		if err != nil {
+			fetchErrorsTotal.WithLabelValues(e.name).Inc()
+			logger.Error(ctx, "[Fetcher] Fetch", zap.Error(err))
+			return err
+		}
+
+		err = e.handler.Handle(ctx, resp)
+	}
+	if err != nil {
+		var decodeErr *DecodeError
+		if errors.As(err, &decodeErr) {
+			decodeErrorsTotal.WithLabelValues(e.name).Inc()
+		}
+		handleErrorsTotal.WithLabelValues(e.name).Inc()
+		logger.Error(ctx, "[Fetcher] Handle", zap.Error(err))
+		return err
+	}
+
+	if err = f.commitCheckpoint(ctx, e); err != nil {
+		logger.Error(ctx, "[Fetcher] Save checkpoint", zap.Error(err))
+		return err
+	}
+
+	// Validators are kept only after a successful Handle, otherwise a failed response would never be delivered again.
+	if req.Method == nethttp.MethodGet {
+		e.setValidators(url, validators)
+	}
+
+	return err
+}
+
+// fetch sends the request, GET requests are made conditional on the validators of the previous response of the handler.
+func (f *Fetcher) fetch(e *entry, req *nethttp.Request) (io.ReadCloser, http.Validators, error) {
+	if req.Method != nethttp.MethodGet {
+		body, err := f.httpClient.Stream(req, f.config.MaxBodySize)
+		return body, http.Validators{}, err
+	}
+
+	return f.httpClient.StreamConditional(req, e.getValidators(req.URL.String()), f.config.MaxBodySize)
 }
 
 func (f *Fetcher) withTracing(ctx context.Context, do func(ctx context.Context) error) {
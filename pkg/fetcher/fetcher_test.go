@@ -0,0 +1,854 @@
+//go:build unit
+// +build unit
+
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	fetcherhttp "github.com/redrru/fantasy-dota/pkg/http"
+	"github.com/redrru/fantasy-dota/pkg/http/replay"
+)
+
+type testHandler struct {
+	url      string
+	refresh  time.Duration
+	handle   func(ctx context.Context, response []byte) error
+	calls    int32
+	inFlight int32
+	overlap  int32
+}
+
+func (h *testHandler) Handle(ctx context.Context, response []byte) error {
+	atomic.AddInt32(&h.calls, 1)
+	if atomic.AddInt32(&h.inFlight, 1) > 1 {
+		atomic.StoreInt32(&h.overlap, 1)
+	}
+	defer atomic.AddInt32(&h.inFlight, -1)
+
+	if h.handle != nil {
+		return h.handle(ctx, response)
+	}
+	return nil
+}
+
+func (h *testHandler) GetRefreshTime() time.Duration {
+	return h.refresh
+}
+
+func (h *testHandler) GetURL() string {
+	return h.url
+}
+
+func newTestServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestFetcherWorkerPool(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	release := make(chan struct{})
+	slow := &testHandler{
+		url:     ts.URL + "/slow",
+		refresh: 10 * time.Millisecond,
+		handle: func(ctx context.Context, response []byte) error {
+			<-release
+			return nil
+		},
+	}
+	fast := &testHandler{
+		url:     ts.URL + "/fast",
+		refresh: 10 * time.Millisecond,
+	}
+
+	f := NewFetcher(Config{Workers: 2})
+	f.RegisterHandlers(slow, fast)
+	go f.Run(context.Background())
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&fast.calls) >= 3
+	}, time.Second, 5*time.Millisecond, "fast handler must not wait for the slow one")
+
+	close(release)
+	assert.NoError(t, f.Close(context.Background()))
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&slow.overlap), "handler must not overlap itself")
+}
+
+type retryTestHandler struct {
+	testHandler
+	policy RetryPolicy
+}
+
+func (h *retryTestHandler) GetRetryPolicy() RetryPolicy {
+	return h.policy
+}
+
+func TestFetcherRetry(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	handler := &retryTestHandler{
+		testHandler: testHandler{url: ts.URL, refresh: time.Hour},
+		policy: RetryPolicy{
+			MaxAttempts: 3,
+			BaseBackoff: time.Millisecond,
+			MaxBackoff:  5 * time.Millisecond,
+			Jitter:      0.5,
+		},
+	}
+
+	f := NewFetcher(Config{})
+	f.RegisterHandlers(handler)
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&handler.calls) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestFetcherRetryReleasesWorker(t *testing.T) {
+	var failing int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/failing" {
+			atomic.AddInt32(&failing, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	retrying := &retryTestHandler{
+		testHandler: testHandler{url: ts.URL + "/failing", refresh: time.Hour},
+		policy: RetryPolicy{
+			MaxAttempts: 3,
+			BaseBackoff: 300 * time.Millisecond,
+		},
+	}
+	healthy := &testHandler{url: ts.URL + "/healthy", refresh: time.Hour}
+
+	f := NewFetcher(Config{Workers: 1})
+	f.RegisterHandlers(retrying)
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&failing) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, f.Add(healthy))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&healthy.calls) == 1
+	}, 200*time.Millisecond, 5*time.Millisecond, "the only worker must not be held during the backoff")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&failing), "the retry is still waiting")
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&failing) == 3
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&retrying.calls))
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, IsTransient(&fetcherhttp.StatusError{StatusCode: http.StatusTooManyRequests}))
+	assert.True(t, IsTransient(&fetcherhttp.StatusError{StatusCode: http.StatusBadGateway}))
+	assert.False(t, IsTransient(&fetcherhttp.StatusError{StatusCode: http.StatusNotFound}))
+	assert.False(t, IsTransient(context.Canceled))
+	assert.False(t, IsTransient(&url.Error{Op: "Get", URL: "https://api.opendota.com", Err: fetcherhttp.ErrRateLimited}))
+}
+
+type notModifiedTestHandler struct {
+	testHandler
+	notModified int32
+}
+
+func (h *notModifiedTestHandler) HandleNotModified(ctx context.Context) error {
+	atomic.AddInt32(&h.notModified, 1)
+	return nil
+}
+
+func TestFetcherConditionalGet(t *testing.T) {
+	const etag = `"v1"`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	handler := &notModifiedTestHandler{
+		testHandler: testHandler{url: ts.URL, refresh: 10 * time.Millisecond},
+	}
+
+	f := NewFetcher(Config{})
+	f.RegisterHandlers(handler)
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&handler.notModified) >= 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&handler.calls))
+}
+
+type namedTestHandler struct {
+	testHandler
+	name string
+}
+
+func (h *namedTestHandler) GetName() string {
+	return h.name
+}
+
+func TestFetcherConditionalGetPerHandler(t *testing.T) {
+	const etag = `"v1"`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	f := NewFetcher(Config{})
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	first := &namedTestHandler{testHandler: testHandler{url: ts.URL, refresh: time.Hour}, name: "first"}
+	assert.NoError(t, f.Add(first))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&first.calls) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	second := &namedTestHandler{testHandler: testHandler{url: ts.URL, refresh: time.Hour}, name: "second"}
+	assert.NoError(t, f.Add(second))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&second.calls) == 1
+	}, time.Second, 5*time.Millisecond, "validators of another handler on the same URL must not be sent")
+
+	assert.NoError(t, f.Remove(first.name))
+	readded := &namedTestHandler{testHandler: testHandler{url: ts.URL, refresh: time.Hour}, name: "first"}
+	assert.NoError(t, f.Add(readded))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&readded.calls) == 1
+	}, time.Second, 5*time.Millisecond, "validators of a removed handler must not be sent")
+}
+
+func TestEntryValidatorsLimit(t *testing.T) {
+	e := &entry{}
+	for i := 0; i < maxValidators+10; i++ {
+		e.setValidators(fmt.Sprintf("https://example.com/?page=%d", i), fetcherhttp.Validators{ETag: strconv.Itoa(i)})
+	}
+	assert.Len(t, e.validators.byURL, maxValidators)
+	assert.Len(t, e.validators.order, maxValidators)
+	assert.Equal(t, fetcherhttp.Validators{}, e.getValidators("https://example.com/?page=0"), "the oldest URL is evicted")
+	assert.Equal(t, fetcherhttp.Validators{ETag: "73"}, e.getValidators("https://example.com/?page=73"))
+
+	e.setValidators("https://example.com/?page=73", fetcherhttp.Validators{})
+	assert.Len(t, e.validators.byURL, maxValidators-1)
+
+	e.resetValidators()
+	assert.Equal(t, fetcherhttp.Validators{}, e.getValidators("https://example.com/?page=72"))
+}
+
+type builderTestHandler struct {
+	testHandler
+}
+
+func (h *builderTestHandler) BuildRequest(ctx context.Context, prev RunState) (*http.Request, error) {
+	url := fmt.Sprintf("%s?run=%d", h.url, prev.Runs)
+	return http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader("query"))
+}
+
+func TestFetcherRequestBuilder(t *testing.T) {
+	runs := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "query", string(body))
+
+		select {
+		case runs <- r.URL.Query().Get("run"):
+		default:
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	handler := &builderTestHandler{
+		testHandler: testHandler{url: ts.URL, refresh: 10 * time.Millisecond},
+	}
+
+	f := NewFetcher(Config{})
+	f.RegisterHandlers(handler)
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	assert.Equal(t, "0", <-runs)
+	assert.Equal(t, "1", <-runs)
+}
+
+func TestFetcherRuntimeRegistration(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	f := NewFetcher(Config{})
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	handler := &testHandler{url: ts.URL, refresh: 10 * time.Millisecond}
+	assert.NoError(t, f.Add(handler))
+	assert.ErrorIs(t, f.Add(handler), ErrHandlerExists)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&handler.calls) >= 1
+	}, time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, f.Pause(ts.URL))
+	time.Sleep(20 * time.Millisecond)
+	paused := atomic.LoadInt32(&handler.calls)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, paused, atomic.LoadInt32(&handler.calls), "paused handler must not run")
+
+	assert.NoError(t, f.SetRefreshTime(ts.URL, time.Hour))
+	assert.NoError(t, f.Resume(ts.URL))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&handler.calls) == paused+1
+	}, time.Second, 5*time.Millisecond, "resume must run handler immediately")
+
+	assert.NoError(t, f.Remove(ts.URL))
+	assert.ErrorIs(t, f.Remove(ts.URL), ErrHandlerNotFound)
+	assert.ErrorIs(t, f.Pause(ts.URL), ErrHandlerNotFound)
+}
+
+func TestFetcherStatusAndTrigger(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	handler := &testHandler{url: ts.URL, refresh: time.Hour}
+
+	f := NewFetcher(Config{})
+	f.RegisterHandlers(handler)
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&handler.calls) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, f.Trigger(ts.URL))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&handler.calls) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.ErrorIs(t, f.Trigger("unknown"), ErrHandlerNotFound)
+
+	assert.Eventually(t, func() bool {
+		return f.Status()[0].Runs == 2
+	}, time.Second, 5*time.Millisecond)
+
+	status := f.Status()
+	assert.Len(t, status, 1)
+	assert.Equal(t, ts.URL, status[0].Name)
+	assert.Equal(t, time.Hour, status[0].RefreshTime)
+	assert.NoError(t, status[0].LastErr)
+	assert.False(t, status[0].LastRun.IsZero())
+	assert.True(t, status[0].NextRun.After(time.Now()))
+}
+
+func TestFetcherMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	handler := &testHandler{url: ts.URL, refresh: time.Hour}
+
+	f := NewFetcher(Config{})
+	f.RegisterHandlers(handler)
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(fetchErrorsTotal.WithLabelValues(ts.URL)) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(runsTotal.WithLabelValues(ts.URL)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(handleErrorsTotal.WithLabelValues(ts.URL)))
+}
+
+func TestFetcherRemoveMetrics(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	handler := &testHandler{
+		url:     ts.URL + "/removed",
+		refresh: time.Hour,
+		handle: func(ctx context.Context, response []byte) error {
+			close(started)
+			<-unblock
+			return fmt.Errorf("handle failed")
+		},
+	}
+
+	f := NewFetcher(Config{})
+	f.RegisterHandlers(handler)
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	<-started
+	assert.NoError(t, f.Remove(handler.url))
+	assert.True(t, hasSeries(t, runsTotal, handler.url), "series of a run in flight are kept")
+
+	close(unblock)
+	assert.Eventually(t, func() bool {
+		return !hasSeries(t, runsTotal, handler.url) && !hasSeries(t, handleErrorsTotal, handler.url)
+	}, time.Second, 5*time.Millisecond, "series must be deleted once the run in flight finishes")
+
+	time.Sleep(20 * time.Millisecond)
+	for _, c := range []prometheus.Collector{runsTotal, handleErrorsTotal, fetchDuration, responseSize, queueWait} {
+		assert.False(t, hasSeries(t, c, handler.url))
+	}
+}
+
+func TestFetcherRemoveReAddMetrics(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	removed := &testHandler{
+		url:     ts.URL + "/readded",
+		refresh: time.Hour,
+		handle: func(ctx context.Context, response []byte) error {
+			close(started)
+			<-unblock
+			return nil
+		},
+	}
+
+	f := NewFetcher(Config{})
+	f.RegisterHandlers(removed)
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	<-started
+	assert.NoError(t, f.Remove(removed.url))
+
+	readded := &testHandler{url: removed.url, refresh: time.Hour}
+	assert.NoError(t, f.Add(readded))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&readded.calls) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	close(unblock)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&removed.inFlight) == 0
+	}, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	assert.True(t, hasSeries(t, runsTotal, readded.url), "the removed entry must not delete the series of the new one")
+	assert.True(t, hasSeries(t, fetchDuration, readded.url))
+}
+
+// hasSeries reports whether c has a series of the handler.
+func hasSeries(t *testing.T, c prometheus.Collector, handler string) bool {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(metrics)
+		close(metrics)
+	}()
+
+	found := false
+	for metric := range metrics {
+		var m dto.Metric
+		assert.NoError(t, metric.Write(&m))
+		for _, label := range m.GetLabel() {
+			if label.GetName() == handlerLabel && label.GetValue() == handler {
+				found = true
+			}
+		}
+	}
+
+	return found
+}
+
+func TestFetcherStandby(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	handler := &testHandler{url: ts.URL, refresh: 10 * time.Millisecond}
+
+	f := NewFetcher(Config{})
+	f.SetActive(false)
+	f.RegisterHandlers(handler)
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&handler.calls), "standby fetcher must not run handlers")
+	assert.ErrorIs(t, f.Trigger(ts.URL), ErrStandby)
+
+	f.SetActive(true)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&handler.calls) >= 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestFetcherStandbyPaused(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	handler := &testHandler{url: ts.URL, refresh: time.Hour}
+
+	f := NewFetcher(Config{})
+	f.SetActive(false)
+	f.RegisterHandlers(handler)
+	assert.NoError(t, f.Pause(ts.URL))
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	// A failover must not run the paused handler.
+	f.SetActive(true)
+	f.SetActive(false)
+	f.SetActive(true)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&handler.calls), "paused handler must not run on activation")
+}
+
+func TestFetcherReplay(t *testing.T) {
+	type hero struct {
+		ID            int      `json:"id"`
+		Name          string   `json:"name"`
+		LocalizedName string   `json:"localized_name"`
+		PrimaryAttr   string   `json:"primary_attr"`
+		Roles         []string `json:"roles"`
+	}
+
+	decoded := make(chan []hero, 1)
+	handler := &testHandler{
+		url:     "https://api.opendota.com/api/heroes",
+		refresh: time.Hour,
+		handle: func(ctx context.Context, response []byte) error {
+			var heroes []hero
+			if err := DecodeJSON(ctx, response, &heroes); err != nil {
+				return err
+			}
+			decoded <- heroes
+			return nil
+		},
+	}
+
+	client := fetcherhttp.NewClient(
+		fetcherhttp.WithTransport(replay.NewTransport(t, "opendota_heroes")),
+		fetcherhttp.WithUserAgent("fantasy-dota/test"),
+	)
+	f := NewFetcher(Config{HTTPClient: client})
+	f.RegisterHandlers(handler)
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	select {
+	case heroes := <-decoded:
+		if assert.Len(t, heroes, 5) {
+			assert.Equal(t, hero{
+				ID:            2,
+				Name:          "npc_dota_hero_axe",
+				LocalizedName: "Axe",
+				PrimaryAttr:   "str",
+				Roles:         []string{"Initiator", "Durable", "Disabler", "Carry"},
+			}, heroes[1])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler was not run")
+	}
+
+	// The run is finished after Handle returns.
+	assert.Eventually(t, func() bool {
+		return f.Status()[0].Runs == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.NoError(t, f.Status()[0].LastErr)
+}
+
+type memoryStore struct {
+	mu          sync.Mutex
+	runs        []RunModel
+	checkpoints map[string][]byte
+}
+
+func (s *memoryStore) SaveRun(ctx context.Context, run RunModel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.runs = append(s.runs, run)
+	return nil
+}
+
+func (s *memoryStore) LoadCheckpoint(ctx context.Context, handler string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.checkpoints[handler], nil
+}
+
+func (s *memoryStore) SaveCheckpoint(ctx context.Context, handler string, checkpoint []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkpoints[handler] = checkpoint
+	return nil
+}
+
+func (s *memoryStore) getCheckpoint(handler string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return string(s.checkpoints[handler])
+}
+
+func TestFetcherCheckpoint(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	store := &memoryStore{checkpoints: map[string][]byte{ts.URL: []byte("41")}}
+
+	seen := make(chan string, 10)
+	handler := &testHandler{
+		url:     ts.URL,
+		refresh: 10 * time.Millisecond,
+		handle: func(ctx context.Context, response []byte) error {
+			last := string(Checkpoint(ctx))
+			select {
+			case seen <- last:
+			default:
+			}
+
+			next, err := strconv.Atoi(last)
+			assert.NoError(t, err)
+			SetCheckpoint(ctx, []byte(strconv.Itoa(next+1)))
+			return nil
+		},
+	}
+
+	f := NewFetcher(Config{})
+	f.SetStore(store)
+	f.RegisterHandlers(handler)
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	assert.Equal(t, "41", <-seen, "handler must resume from the persisted checkpoint")
+	assert.Equal(t, "42", <-seen)
+	assert.Eventually(t, func() bool {
+		return store.getCheckpoint(ts.URL) >= "43"
+	}, time.Second, 5*time.Millisecond)
+
+	store.mu.Lock()
+	assert.NotEmpty(t, store.runs)
+	assert.Equal(t, ts.URL, store.runs[0].Handler)
+	store.mu.Unlock()
+}
+
+type streamTestHandler struct {
+	url   string
+	items chan int
+}
+
+func (h *streamTestHandler) GetRefreshTime() time.Duration {
+	return time.Hour
+}
+
+func (h *streamTestHandler) GetURL() string {
+	return h.url
+}
+
+func (h *streamTestHandler) HandleStream(ctx context.Context, body io.Reader) error {
+	decoder := json.NewDecoder(body)
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+	for decoder.More() {
+		var item int
+		if err := decoder.Decode(&item); err != nil {
+			return err
+		}
+		h.items <- item
+	}
+	return nil
+}
+
+func TestFetcherStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(`[1, 2, 3]`))
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	handler := &streamTestHandler{url: ts.URL, items: make(chan int, 3)}
+
+	f := NewFetcher(Config{MaxBodySize: 1024})
+	f.RegisterHandlers(Stream(handler))
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	assert.Equal(t, 1, <-handler.items)
+	assert.Equal(t, 2, <-handler.items)
+	assert.Equal(t, 3, <-handler.items)
+}
+
+func TestFetcherClose(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	started := make(chan struct{}, 2)
+	cancelled := make(chan struct{})
+	finished := make(chan struct{})
+
+	slow := &testHandler{
+		url:     ts.URL + "/slow",
+		refresh: time.Hour,
+		handle: func(ctx context.Context, response []byte) error {
+			started <- struct{}{}
+			<-ctx.Done()
+			close(cancelled)
+			return ctx.Err()
+		},
+	}
+	draining := &testHandler{
+		url:     ts.URL + "/draining",
+		refresh: time.Hour,
+		handle: func(ctx context.Context, response []byte) error {
+			started <- struct{}{}
+			time.Sleep(20 * time.Millisecond)
+			close(finished)
+			return nil
+		},
+	}
+
+	f := NewFetcher(Config{Workers: 2})
+	f.RegisterHandlers(slow, draining)
+	go f.Run(context.Background())
+
+	<-started
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err := f.Close(ctx)
+
+	var shutdownErr *ShutdownError
+	assert.ErrorAs(t, err, &shutdownErr)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, []string{slow.url}, shutdownErr.Interrupted)
+
+	<-finished
+	<-cancelled
+}
+
+func TestFetcherCloseWaitsCancelled(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	started := make(chan struct{})
+	var exited int32
+	handler := &testHandler{
+		url:     ts.URL,
+		refresh: time.Hour,
+		handle: func(ctx context.Context, response []byte) error {
+			close(started)
+			<-ctx.Done()
+			// Cleanup after cancellation, e.g. saving the state, must finish before Close returns.
+			time.Sleep(50 * time.Millisecond)
+			atomic.StoreInt32(&exited, 1)
+			return ctx.Err()
+		},
+	}
+
+	f := NewFetcher(Config{})
+	f.RegisterHandlers(handler)
+	go f.Run(context.Background())
+
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var shutdownErr *ShutdownError
+	assert.ErrorAs(t, f.Close(ctx), &shutdownErr)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&exited), "Close must wait for the cancelled runs")
+}
+
+func TestFetcherRunContext(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	started := make(chan struct{})
+	handler := &testHandler{
+		url:     ts.URL,
+		refresh: time.Hour,
+		handle: func(ctx context.Context, response []byte) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+
+	f := NewFetcher(Config{})
+	f.RegisterHandlers(handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.Run(ctx)
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run must return once its context is cancelled")
+	}
+	assert.NoError(t, f.Close(context.Background()))
+}
@@ -0,0 +1,78 @@
+package fetcher
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	metricsNamespace = "fetcher"
+	handlerLabel     = "handler"
+)
+
+var (
+	runsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "runs_total",
+		Help:      "Number of handler runs including retries.",
+	}, []string{handlerLabel})
+
+	fetchErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "fetch_errors_total",
+		Help:      "Number of failed upstream requests.",
+	}, []string{handlerLabel})
+
+	handleErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "handle_errors_total",
+		Help:      "Number of errors returned by handlers.",
+	}, []string{handlerLabel})
+
+	decodeErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "decode_errors_total",
+		Help:      "Number of responses that failed decoding or validation.",
+	}, []string{handlerLabel})
+
+	panicsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "panics_total",
+		Help:      "Number of recovered handler panics.",
+	}, []string{handlerLabel})
+
+	fetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "fetch_duration_seconds",
+		Help:      "Latency of upstream requests.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{handlerLabel})
+
+	responseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "response_size_bytes",
+		Help:      "Size of upstream response bodies.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 8),
+	}, []string{handlerLabel})
+
+	queueWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_wait_seconds",
+		Help:      "Time a scheduled run waits for a free worker.",
+		Buckets:   []float64{.001, .01, .1, .5, 1, 5, 10, 30, 60},
+	}, []string{handlerLabel})
+)
+
+// deleteMetrics drops the series of a removed handler.
+func deleteMetrics(name string) {
+	labels := prometheus.Labels{handlerLabel: name}
+
+	runsTotal.Delete(labels)
+	fetchErrorsTotal.Delete(labels)
+	handleErrorsTotal.Delete(labels)
+	decodeErrorsTotal.Delete(labels)
+	panicsTotal.Delete(labels)
+	fetchDuration.Delete(labels)
+	responseSize.Delete(labels)
+	queueWait.Delete(labels)
+}
@@ -0,0 +1,426 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/redrru/fantasy-dota/pkg/log"
+)
+
+var (
+	ErrHandlerExists   = errors.New("handler already registered")
+	ErrHandlerNotFound = errors.New("handler not found")
+	ErrInvalidRefresh  = errors.New("refresh time must be positive")
+	ErrInvalidSchedule = errors.New("schedule must not be nil")
+	ErrStandby         = errors.New("fetcher is in standby")
+)
+
+// NamedHandler may be implemented by a Handler to be addressed by name, GetURL is used otherwise.
+type NamedHandler interface {
+	GetName() string
+}
+
+type entry struct {
+	name    string
+	handler Handler
+	// impl is checked for optional interfaces, it differs from handler for adapters like JSON.
+	impl     interface{}
+	inFlight chan struct{}
+	// cleanup runs deleteMetrics of a removed handler once.
+	cleanup       sync.Once
+	deleteMetrics func()
+
+	trigger chan struct{}
+	reset   chan struct{}
+	stop    chan struct{}
+
+	loadMu sync.Mutex
+	loaded bool
+
+	validatorsMu sync.Mutex
+	validators   validators
+
+	mu       sync.RWMutex
+	state    RunState
+	refresh  time.Duration
+	schedule Schedule
+	paused   bool
+	next     time.Time
+}
+
+// HandlerStatus is a snapshot of a registered handler.
+type HandlerStatus struct {
+	RunState
+
+	Name        string
+	URL         string
+	Paused      bool
+	RefreshTime time.Duration
+	Schedule    string
+	NextRun     time.Time
+}
+
+// Add registers the handler, it starts polling immediately if the fetcher is running.
+func (f *Fetcher) Add(handler Handler) error {
+	e := f.newEntry(handler)
+	if _, ok := e.impl.(ScheduledHandler); ok && e.schedule == nil {
+		return ErrInvalidSchedule
+	}
+	if e.schedule == nil {
+		return ErrInvalidRefresh
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.entries[e.name]; ok {
+		return ErrHandlerExists
+	}
+	f.entries[e.name] = e
+
+	if f.running {
+		go f.schedule(e)
+	}
+
+	log.GetLogger().Info(context.Background(), "[Fetcher] Handler added", zap.String("handler", e.name))
+
+	return nil
+}
+
+// Remove stops polling the handler, a run in flight is not interrupted.
+func (f *Fetcher) Remove(name string) error {
+	f.mu.Lock()
+	e, ok := f.entries[name]
+	if !ok {
+		f.mu.Unlock()
+		return ErrHandlerNotFound
+	}
+	delete(f.entries, name)
+	close(e.stop)
+	f.mu.Unlock()
+
+	e.resetValidators()
+	// Runs in flight still report to the series, they are dropped once the last one releases its slot.
+	e.drained()
+
+	log.GetLogger().Info(context.Background(), "[Fetcher] Handler removed", zap.String("handler", name))
+
+	return nil
+}
+
+// Pause skips scheduled runs of the handler until Resume.
+func (f *Fetcher) Pause(name string) error {
+	e, err := f.getEntry(name)
+	if err != nil {
+		return err
+	}
+
+	e.setPaused(true)
+
+	return nil
+}
+
+// Resume continues scheduled runs of the handler and runs it immediately.
+func (f *Fetcher) Resume(name string) error {
+	e, err := f.getEntry(name)
+	if err != nil {
+		return err
+	}
+
+	e.setPaused(false)
+	e.notify(e.trigger)
+
+	return nil
+}
+
+// SetRefreshTime changes the polling interval of the handler, the next run is rescheduled from now.
+func (f *Fetcher) SetRefreshTime(name string, refresh time.Duration) error {
+	if refresh <= 0 {
+		return ErrInvalidRefresh
+	}
+
+	e, err := f.getEntry(name)
+	if err != nil {
+		return err
+	}
+
+	e.setSchedule(Every(refresh), refresh)
+
+	return nil
+}
+
+// SetSchedule replaces the schedule of the handler, the next run is rescheduled from now.
+func (f *Fetcher) SetSchedule(name string, schedule Schedule) error {
+	if schedule == nil {
+		return ErrInvalidSchedule
+	}
+
+	e, err := f.getEntry(name)
+	if err != nil {
+		return err
+	}
+
+	e.setSchedule(schedule, 0)
+
+	return nil
+}
+
+// Trigger runs the handler immediately, even if it is paused.
+func (f *Fetcher) Trigger(name string) error {
+	e, err := f.getEntry(name)
+	if err != nil {
+		return err
+	}
+	if !f.isActive() {
+		return ErrStandby
+	}
+
+	e.notify(e.trigger)
+
+	return nil
+}
+
+// Status returns snapshots of all registered handlers sorted by name.
+func (f *Fetcher) Status() []HandlerStatus {
+	f.mu.RLock()
+	result := make([]HandlerStatus, 0, len(f.entries))
+	for _, e := range f.entries {
+		result = append(result, e.status())
+	}
+	f.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+func (f *Fetcher) getEntry(name string) (*entry, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	e, ok := f.entries[name]
+	if !ok {
+		return nil, ErrHandlerNotFound
+	}
+
+	return e, nil
+}
+
+func (f *Fetcher) newEntry(handler Handler) *entry {
+	impl := implementation(handler)
+
+	maxInFlight := f.config.MaxInFlight
+	if h, ok := impl.(ConcurrentHandler); ok && h.GetMaxInFlight() > 0 {
+		maxInFlight = h.GetMaxInFlight()
+	}
+
+	name := handler.GetURL()
+	if h, ok := impl.(NamedHandler); ok {
+		name = h.GetName()
+	}
+
+	e := &entry{
+		name:     name,
+		handler:  handler,
+		impl:     impl,
+		inFlight: make(chan struct{}, maxInFlight),
+		trigger:  make(chan struct{}, 1),
+		reset:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+	e.deleteMetrics = func() { f.deleteEntryMetrics(e) }
+
+	if h, ok := impl.(ScheduledHandler); ok {
+		e.schedule = h.GetSchedule()
+	} else if refresh := handler.GetRefreshTime(); refresh > 0 {
+		e.refresh = refresh
+		e.schedule = Every(refresh)
+	}
+
+	return e
+}
+
+// schedule enqueues the entry according to its schedule until the entry is removed or the fetcher is closed.
+// Interval handlers are also enqueued immediately.
+func (f *Fetcher) schedule(e *entry) {
+	var timer *time.Timer
+	if e.isInterval() {
+		timer = time.NewTimer(e.setNext(0))
+	} else {
+		timer = time.NewTimer(e.nextDelay())
+	}
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-f.close:
+			return
+		case <-e.stop:
+			return
+		case <-e.trigger:
+			if !f.isActive() {
+				continue
+			}
+			if !f.enqueue(e) {
+				return
+			}
+		case <-e.reset:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(e.nextDelay())
+		case <-timer.C:
+			timer.Reset(e.nextDelay())
+			if e.isPaused() || !f.isActive() {
+				continue
+			}
+			if !f.enqueue(e) {
+				return
+			}
+		}
+	}
+}
+
+// notify sends a non-blocking signal, a pending signal is enough for the scheduler.
+func (e *entry) notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (e *entry) status() HandlerStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return HandlerStatus{
+		RunState:    e.state,
+		Name:        e.name,
+		URL:         e.handler.GetURL(),
+		Paused:      e.paused,
+		RefreshTime: e.refresh,
+		Schedule:    scheduleString(e.schedule),
+		NextRun:     e.next,
+	}
+}
+
+// setNext remembers when the next scheduled run happens and returns the delay.
+func (e *entry) setNext(d time.Duration) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.next = time.Now().Add(d)
+
+	return d
+}
+
+// nextDelay computes the next run from the schedule and returns the delay until it.
+func (e *entry) nextDelay() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	e.next = e.schedule.Next(now)
+	if e.next.IsZero() {
+		return math.MaxInt64
+	}
+
+	return e.next.Sub(now)
+}
+
+func (e *entry) isInterval() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.refresh > 0
+}
+
+func (e *entry) setSchedule(schedule Schedule, refresh time.Duration) {
+	e.mu.Lock()
+	e.schedule = schedule
+	e.refresh = refresh
+	e.mu.Unlock()
+
+	e.notify(e.reset)
+}
+
+func scheduleString(schedule Schedule) string {
+	if s, ok := schedule.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return ""
+}
+
+func (e *entry) isPaused() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.paused
+}
+
+func (e *entry) setPaused(paused bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.paused = paused
+}
+
+func (e *entry) stopped() bool {
+	select {
+	case <-e.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire reserves an in-flight slot, it returns false if the handler is already running at its limit or is removed.
+func (e *entry) acquire() bool {
+	select {
+	case e.inFlight <- struct{}{}:
+	default:
+		return false
+	}
+
+	if e.stopped() {
+		e.release()
+		return false
+	}
+
+	return true
+}
+
+func (e *entry) release() {
+	<-e.inFlight
+	e.drained()
+}
+
+// drained deletes the metrics of a removed handler when no run holds a slot, so that no run recreates them afterwards.
+func (e *entry) drained() {
+	if e.stopped() && len(e.inFlight) == 0 {
+		e.cleanup.Do(e.deleteMetrics)
+	}
+}
+
+// deleteEntryMetrics drops the series of the removed entry unless a handler added again under the same name reports to them.
+func (f *Fetcher) deleteEntryMetrics(e *entry) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if current, ok := f.entries[e.name]; ok && current != e {
+		return
+	}
+	deleteMetrics(e.name)
+}
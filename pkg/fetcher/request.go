@@ -0,0 +1,57 @@
+package fetcher
+
+import (
+	"context"
+	nethttp "net/http"
+	"time"
+)
+
+// RequestBuilder may be implemented by a Handler to build the whole request
+// (method, query, headers, body) instead of a plain GET of GetURL.
+type RequestBuilder interface {
+	BuildRequest(ctx context.Context, prev RunState) (*nethttp.Request, error)
+}
+
+// RunState describes the previous runs of a handler.
+type RunState struct {
+	// Runs is the number of finished runs.
+	Runs         int
+	LastRun      time.Time
+	LastSuccess  time.Time
+	LastDuration time.Duration
+	LastURL      string
+	LastErr      error
+	// Checkpoint is the last checkpoint saved by the handler with SetCheckpoint.
+	Checkpoint []byte
+}
+
+func (f *Fetcher) buildRequest(ctx context.Context, e *entry) (*nethttp.Request, error) {
+	if builder, ok := e.impl.(RequestBuilder); ok {
+		return builder.BuildRequest(ctx, e.getState())
+	}
+
+	return nethttp.NewRequestWithContext(ctx, nethttp.MethodGet, e.handler.GetURL(), nil)
+}
+
+func (e *entry) getState() RunState {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.state
+}
+
+func (e *entry) finishRun(started time.Time, url string, err error) RunState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.state.Runs++
+	e.state.LastRun = started
+	e.state.LastDuration = time.Since(started)
+	e.state.LastURL = url
+	e.state.LastErr = err
+	if err == nil {
+		e.state.LastSuccess = started
+	}
+
+	return e.state
+}
@@ -0,0 +1,88 @@
+package fetcher
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	nethttp "net/http"
+	"time"
+
+	"github.com/redrru/fantasy-dota/pkg/http"
+)
+
+// RetryableHandler may be implemented by a Handler to retry failed runs
+// instead of waiting for the next refresh tick.
+type RetryableHandler interface {
+	GetRetryPolicy() RetryPolicy
+}
+
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one.
+	MaxAttempts int
+	// BaseBackoff is the delay before the first retry, it doubles on every next one.
+	BaseBackoff time.Duration
+	// MaxBackoff caps the delay between attempts.
+	MaxBackoff time.Duration
+	// Jitter is a fraction in [0, 1] of the delay that is randomly subtracted from it.
+	Jitter float64
+	// Retryable reports whether the error is worth retrying, IsTransient is used if nil.
+	Retryable func(err error) bool
+}
+
+var noRetry = RetryPolicy{MaxAttempts: 1}
+
+// IsTransient reports whether err is a network error or a 429/5xx response of http.Client.
+// A request rejected by the client rate limiter is not transient, it would not be allowed on a retry either.
+func IsTransient(err error) bool {
+	if status := http.StatusCode(err); status != 0 {
+		return status == nethttp.StatusTooManyRequests || status >= nethttp.StatusInternalServerError
+	}
+	if errors.Is(err, http.ErrRateLimited) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func retryPolicy(handler interface{}) RetryPolicy {
+	h, ok := handler.(RetryableHandler)
+	if !ok {
+		return noRetry
+	}
+
+	policy := h.GetRetryPolicy()
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = IsTransient
+	}
+
+	return policy
+}
+
+func (p RetryPolicy) shouldRetry(attempt int, err error) bool {
+	return err != nil && attempt < p.MaxAttempts && p.Retryable(err)
+}
+
+// backoff returns the delay after the given attempt, attempts are counted from 1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 && d > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d -= time.Duration(rand.Float64() * jitter * float64(d)) //nolint:gosec // jitter does not need crypto rand
+	}
+
+	return d
+}
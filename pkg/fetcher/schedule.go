@@ -0,0 +1,277 @@
+package fetcher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when a handler runs next.
+type Schedule interface {
+	// Next returns the time of the run following after, zero time means there are no more runs.
+	Next(after time.Time) time.Time
+}
+
+// ScheduledHandler may be implemented by a Handler to run on a Schedule instead of
+// every GetRefreshTime. Such handlers are not run on registration, only at the scheduled times.
+type ScheduledHandler interface {
+	GetSchedule() Schedule
+}
+
+type everySchedule struct {
+	interval time.Duration
+}
+
+// Every runs a handler with a fixed interval counted from the previous run.
+func Every(interval time.Duration) Schedule {
+	return everySchedule{interval: interval}
+}
+
+func (s everySchedule) Next(after time.Time) time.Time {
+	return after.Add(s.interval)
+}
+
+func (s everySchedule) String() string {
+	return "@every " + s.interval.String()
+}
+
+type alignedSchedule struct {
+	interval time.Duration
+	loc      *time.Location
+}
+
+// Aligned runs a handler on wall clock multiples of interval counted from midnight in loc (UTC if nil),
+// e.g. Aligned(15*time.Minute, time.UTC) runs at :00, :15, :30 and :45. The interval should divide 24h.
+// On DST transition days the runs keep to the wall clock, so the skipped hour has no runs.
+func Aligned(interval time.Duration, loc *time.Location) (Schedule, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("aligned: interval must be positive, got %s", interval)
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	return alignedSchedule{interval: interval, loc: loc}, nil
+}
+
+// MustAligned is like Aligned but panics if the interval is not positive.
+func MustAligned(interval time.Duration, loc *time.Location) Schedule {
+	s, err := Aligned(interval, loc)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+func (s alignedSchedule) Next(after time.Time) time.Time {
+	after = after.In(s.loc)
+	year, month, day := after.Date()
+	tomorrow := time.Date(year, month, day+1, 0, 0, 0, 0, s.loc)
+
+	// The slots are counted on the wall clock rather than in elapsed time from midnight,
+	// which is an hour off after a DST transition.
+	wall := time.Duration(after.Hour())*time.Hour + time.Duration(after.Minute())*time.Minute +
+		time.Duration(after.Second())*time.Second + time.Duration(after.Nanosecond())
+
+	for slot := (wall/s.interval + 1) * s.interval; slot < 24*time.Hour; slot += s.interval {
+		// time.Date normalizes the wall clock slots skipped by a DST jump forward past the jump.
+		if next := time.Date(year, month, day, 0, 0, 0, int(slot), s.loc); next.After(after) && next.Before(tomorrow) {
+			return next
+		}
+	}
+
+	return tomorrow
+}
+
+func (s alignedSchedule) String() string {
+	return fmt.Sprintf("@aligned %s %s", s.interval, s.loc)
+}
+
+type cronField struct {
+	min, max int
+}
+
+var (
+	secondField = cronField{0, 59}
+	minuteField = cronField{0, 59}
+	hourField   = cronField{0, 23}
+	domField    = cronField{1, 31}
+	monthField  = cronField{1, 12}
+	dowField    = cronField{0, 7}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+type cronSchedule struct {
+	expr string
+	loc  *time.Location
+
+	second, minute, hour, dom, month, dow uint64
+	domAny, dowAny                        bool
+}
+
+// Cron parses a cron expression evaluated in loc (UTC if nil). Both the standard five fields
+// "minute hour day-of-month month day-of-week" and six fields with leading seconds are accepted,
+// each field supports "*", lists, ranges and steps. Descriptors like "@daily" or "@hourly" are also accepted.
+//
+// For example "0 4 * * *" runs daily at 04:00 and "*/30 * 10-22 * * *" runs every 30 seconds from 10:00 till 22:59.
+func Cron(expr string, loc *time.Location) (Schedule, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	spec := strings.TrimSpace(expr)
+	if descriptor, ok := cronDescriptors[spec]; ok {
+		spec = descriptor
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron %q: expected 5 or 6 fields, got %d", expr, len(fields))
+	}
+
+	s := &cronSchedule{expr: expr, loc: loc}
+
+	var err error
+	for i, f := range []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&s.second, secondField},
+		{&s.minute, minuteField},
+		{&s.hour, hourField},
+		{&s.dom, domField},
+		{&s.month, monthField},
+		{&s.dow, dowField},
+	} {
+		if *f.bits, err = parseCronField(fields[i], f.field); err != nil {
+			return nil, fmt.Errorf("cron %q: %w", expr, err)
+		}
+	}
+
+	// Sunday may be written both as 0 and 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = fields[3] == "*"
+	s.dowAny = fields[5] == "*"
+
+	return s, nil
+}
+
+// MustCron is like Cron but panics if the expression is invalid.
+func MustCron(expr string, loc *time.Location) Schedule {
+	s, err := Cron(expr, loc)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+func parseCronField(value string, field cronField) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(value, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		from, to := field.min, field.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid range in %q", part)
+			}
+			if to, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("invalid range in %q", part)
+			}
+		default:
+			var err error
+			if from, err = strconv.Atoi(rangePart); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			if strings.Contains(part, "/") {
+				to = field.max
+			} else {
+				to = from
+			}
+		}
+
+		if from < field.min || to > field.max || from > to {
+			return 0, fmt.Errorf("%q is out of range [%d, %d]", part, field.min, field.max)
+		}
+
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	t := after.In(s.loc).Truncate(time.Second).Add(time.Second)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case !has(s.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+		case !has(s.hour, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.loc)
+		case !has(s.minute, t.Minute()):
+			t = t.Truncate(time.Minute).Add(time.Minute)
+		case !has(s.second, t.Second()):
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// dayMatches follows the cron convention: if both day fields are restricted, matching either is enough.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := has(s.dom, t.Day())
+	dowMatch := has(s.dow, int(t.Weekday()))
+
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
+
+func (s *cronSchedule) String() string {
+	return fmt.Sprintf("%s %s", s.expr, s.loc)
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
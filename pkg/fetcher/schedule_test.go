@@ -0,0 +1,136 @@
+//go:build unit
+// +build unit
+
+package fetcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronNext(t *testing.T) {
+	type args struct {
+		expr  string
+		loc   string
+		after time.Time
+	}
+	type want struct {
+		next time.Time
+		err  bool
+	}
+
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name string
+		args args
+		want want
+	}{
+		{
+			name: "DailyAt4UTC",
+			args: args{expr: "0 4 * * *", after: time.Date(2022, 5, 23, 10, 0, 0, 0, time.UTC)},
+			want: want{next: time.Date(2022, 5, 24, 4, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "DailyAt4Moscow",
+			args: args{expr: "0 4 * * *", loc: moscow.String(), after: time.Date(2022, 5, 23, 0, 0, 0, 0, time.UTC)},
+			want: want{next: time.Date(2022, 5, 23, 4, 0, 0, 0, moscow)},
+		},
+		{
+			name: "EverySecondsDuringHours",
+			args: args{expr: "*/30 * 10-22 * * *", after: time.Date(2022, 5, 23, 10, 0, 10, 0, time.UTC)},
+			want: want{next: time.Date(2022, 5, 23, 10, 0, 30, 0, time.UTC)},
+		},
+		{
+			name: "OutsideHours",
+			args: args{expr: "*/30 * 10-22 * * *", after: time.Date(2022, 5, 23, 22, 59, 45, 0, time.UTC)},
+			want: want{next: time.Date(2022, 5, 24, 10, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "WeekdayList",
+			args: args{expr: "15 12 * * 1,3,5", after: time.Date(2022, 5, 24, 0, 0, 0, 0, time.UTC)},
+			want: want{next: time.Date(2022, 5, 25, 12, 15, 0, 0, time.UTC)},
+		},
+		{
+			name: "Sunday7",
+			args: args{expr: "0 0 * * 7", after: time.Date(2022, 5, 23, 0, 0, 0, 0, time.UTC)},
+			want: want{next: time.Date(2022, 5, 29, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "Descriptor",
+			args: args{expr: "@hourly", after: time.Date(2022, 5, 23, 10, 30, 0, 0, time.UTC)},
+			want: want{next: time.Date(2022, 5, 23, 11, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "Never",
+			args: args{expr: "0 0 30 2 *", after: time.Date(2022, 5, 23, 10, 30, 0, 0, time.UTC)},
+			want: want{next: time.Time{}},
+		},
+		{
+			name: "WrongFields",
+			args: args{expr: "* * *"},
+			want: want{err: true},
+		},
+		{
+			name: "OutOfRange",
+			args: args{expr: "0 24 * * *"},
+			want: want{err: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			loc := time.UTC
+			if tc.args.loc != "" {
+				loc = moscow
+			}
+
+			schedule, err := Cron(tc.args.expr, loc)
+			if tc.want.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, tc.want.next.Equal(schedule.Next(tc.args.after)), "got %v", schedule.Next(tc.args.after))
+		})
+	}
+}
+
+func TestAlignedNext(t *testing.T) {
+	s := MustAligned(15*time.Minute, time.UTC)
+
+	assert.Equal(t, time.Date(2022, 5, 23, 10, 15, 0, 0, time.UTC), s.Next(time.Date(2022, 5, 23, 10, 7, 0, 0, time.UTC)))
+	assert.Equal(t, time.Date(2022, 5, 23, 10, 30, 0, 0, time.UTC), s.Next(time.Date(2022, 5, 23, 10, 15, 0, 0, time.UTC)))
+	assert.Equal(t, time.Date(2022, 5, 24, 0, 0, 0, 0, time.UTC), s.Next(time.Date(2022, 5, 23, 23, 50, 0, 0, time.UTC)))
+
+	for _, interval := range []time.Duration{0, -time.Minute} {
+		_, err := Aligned(interval, time.UTC)
+		assert.Error(t, err, interval)
+	}
+}
+
+func TestAlignedNextDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	s := MustAligned(6*time.Hour, loc)
+
+	// 2022-03-27 clocks jump from 02:00 to 03:00, 2022-10-30 from 03:00 back to 02:00.
+	assert.Equal(t, time.Date(2022, 3, 27, 6, 0, 0, 0, loc), s.Next(time.Date(2022, 3, 27, 0, 0, 0, 0, loc)))
+	assert.Equal(t, time.Date(2022, 3, 27, 12, 0, 0, 0, loc), s.Next(time.Date(2022, 3, 27, 6, 0, 0, 0, loc)))
+	assert.Equal(t, time.Date(2022, 10, 30, 6, 0, 0, 0, loc), s.Next(time.Date(2022, 10, 30, 0, 0, 0, 0, loc)))
+	assert.Equal(t, time.Date(2022, 10, 31, 0, 0, 0, 0, loc), s.Next(time.Date(2022, 10, 30, 18, 0, 0, 0, loc)))
+
+	// 02:30 does not exist on the spring day, the slot runs after the jump.
+	s = MustAligned(30*time.Minute, loc)
+	next := s.Next(time.Date(2022, 3, 27, 1, 45, 0, 0, loc))
+	assert.Equal(t, time.Date(2022, 3, 27, 3, 0, 0, 0, loc), next)
+	assert.Equal(t, time.Date(2022, 3, 27, 3, 30, 0, 0, loc), s.Next(next))
+}
@@ -0,0 +1,129 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/redrru/fantasy-dota/pkg/log"
+)
+
+const (
+	saveRunTimeout = 5 * time.Second
+	// cancelGracePeriod is how long Close waits for the cancelled runs to save their state and exit.
+	cancelGracePeriod = saveRunTimeout + time.Second
+)
+
+// ShutdownError is returned by Close if some handlers did not finish before the deadline.
+type ShutdownError struct {
+	// Interrupted are the names of the handlers whose runs were cancelled.
+	Interrupted []string
+	Err         error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("fetcher shutdown: %v, interrupted handlers: %s", e.Err, strings.Join(e.Interrupted, ", "))
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// Close stops scheduling new runs and waits for the in-flight handlers until ctx is done.
+// Handlers still running after that have their contexts cancelled and are reported in *ShutdownError,
+// Close waits up to cancelGracePeriod more for them to exit, so that the store is not closed under them.
+func (f *Fetcher) Close(ctx context.Context) error {
+	f.stop()
+	defer f.cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.GetLogger().Debug(ctx, "[Fetcher] Exited")
+		return nil
+	case <-ctx.Done():
+	}
+
+	interrupted := f.activeHandlers()
+	f.cancel()
+
+	select {
+	case <-drained:
+	case <-time.After(cancelGracePeriod):
+		log.GetLogger().Warn(ctx, "[Fetcher] Handlers did not exit after cancellation", zap.Duration("grace_period", cancelGracePeriod))
+	}
+
+	for _, name := range interrupted {
+		log.GetLogger().Warn(ctx, "[Fetcher] Handler interrupted by shutdown", zap.String("handler", name))
+	}
+	if len(interrupted) == 0 {
+		return nil
+	}
+
+	return &ShutdownError{Interrupted: interrupted, Err: ctx.Err()}
+}
+
+// stop closes the fetcher for new runs, it may be called several times.
+func (f *Fetcher) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.closed {
+		f.closed = true
+		close(f.close)
+	}
+}
+
+func (f *Fetcher) isClosed() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.closed
+}
+
+func (f *Fetcher) begin(name string) {
+	f.activeMu.Lock()
+	defer f.activeMu.Unlock()
+
+	f.active[name]++
+}
+
+func (f *Fetcher) end(name string) {
+	f.activeMu.Lock()
+	defer f.activeMu.Unlock()
+
+	if f.active[name]--; f.active[name] <= 0 {
+		delete(f.active, name)
+	}
+}
+
+func (f *Fetcher) activeHandlers() []string {
+	f.activeMu.Lock()
+	defer f.activeMu.Unlock()
+
+	names := make([]string, 0, len(f.active))
+	for name := range f.active {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// detach returns a context for bookkeeping after a run that may have been interrupted by shutdown.
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Err() == nil {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(context.Background(), saveRunTimeout)
+}
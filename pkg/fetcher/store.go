@@ -0,0 +1,235 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/redrru/fantasy-dota/pkg/db"
+	"github.com/redrru/fantasy-dota/pkg/log"
+	"github.com/redrru/fantasy-dota/pkg/tracing"
+)
+
+// StateStore persists run history and checkpoints of handlers across restarts.
+type StateStore interface {
+	SaveRun(ctx context.Context, run RunModel) error
+	LoadCheckpoint(ctx context.Context, handler string) ([]byte, error)
+	SaveCheckpoint(ctx context.Context, handler string, checkpoint []byte) error
+}
+
+type RunModel struct {
+	ID         int64     `gorm:"primaryKey"`
+	Handler    string    `gorm:"not null;index:idx_fetcher_runs_handler_started_at,priority:1"`
+	URL        string    `gorm:"not null"`
+	StartedAt  time.Time `gorm:"not null;index:idx_fetcher_runs_handler_started_at,priority:2"`
+	DurationMs int64     `gorm:"not null"`
+	Error      string
+}
+
+func (m *RunModel) TableName() string {
+	return "fetcher_runs"
+}
+
+type CheckpointModel struct {
+	Handler   string `gorm:"primaryKey"`
+	Data      []byte
+	UpdatedAt time.Time
+}
+
+func (m *CheckpointModel) TableName() string {
+	return "fetcher_checkpoints"
+}
+
+// StoreModels returns the models of DBStore to be registered for migration.
+func StoreModels() []interface{} {
+	return []interface{}{RunModel{}, CheckpointModel{}}
+}
+
+// historyPruneInterval is how often the expired runs of a handler are deleted.
+const historyPruneInterval = time.Minute
+
+type DBStore struct {
+	db        *db.DB
+	retention time.Duration
+
+	mu        sync.Mutex
+	lastPrune map[string]time.Time
+}
+
+// NewDBStore creates Postgres backed StateStore, runs older than retention are deleted (never if retention is 0).
+func NewDBStore(db *db.DB, retention time.Duration) *DBStore {
+	return &DBStore{db: db, retention: retention, lastPrune: map[string]time.Time{}}
+}
+
+func (s *DBStore) SaveRun(ctx context.Context, run RunModel) error {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "FetcherSaveRun")
+	defer span.End()
+
+	if err := s.db.Conn(ctx).Create(&run).Error; err != nil {
+		return err
+	}
+
+	return s.prune(ctx, run.Handler)
+}
+
+// prune deletes the runs of handler older than the retention at most once per historyPruneInterval,
+// so that frequent handlers do not double the writes to the primary.
+func (s *DBStore) prune(ctx context.Context, handler string) error {
+	if s.retention <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	if now.Sub(s.lastPrune[handler]) < historyPruneInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastPrune[handler] = now
+	s.mu.Unlock()
+
+	return s.db.Conn(ctx).
+		Where("handler = ? AND started_at < ?", handler, now.Add(-s.retention)).
+		Delete(&RunModel{}).Error
+}
+
+func (s *DBStore) LoadCheckpoint(ctx context.Context, handler string) ([]byte, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "FetcherLoadCheckpoint")
+	defer span.End()
+
+	// The checkpoint was written to the primary by the previous run, a lagging replica would return an older one.
+	var model CheckpointModel
+	err := s.db.Conn(db.ForcePrimary(ctx)).Where("handler = ?", handler).Take(&model).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return model.Data, nil
+}
+
+func (s *DBStore) SaveCheckpoint(ctx context.Context, handler string, checkpoint []byte) error {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "FetcherSaveCheckpoint")
+	defer span.End()
+
+	model := CheckpointModel{Handler: handler, Data: checkpoint, UpdatedAt: time.Now()}
+
+	return s.db.Conn(ctx).
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&model).Error
+}
+
+type checkpointKey struct{}
+
+type runCheckpoint struct {
+	prev []byte
+	next []byte
+	set  bool
+}
+
+// Checkpoint returns the checkpoint saved by the last successful run of the handler, it is meant to be called from Handle.
+func Checkpoint(ctx context.Context) []byte {
+	if cp, ok := ctx.Value(checkpointKey{}).(*runCheckpoint); ok {
+		return cp.prev
+	}
+	return nil
+}
+
+// SetCheckpoint stores the checkpoint of the current run, it is persisted only if the run succeeds.
+func SetCheckpoint(ctx context.Context, checkpoint []byte) {
+	if cp, ok := ctx.Value(checkpointKey{}).(*runCheckpoint); ok {
+		cp.next = checkpoint
+		cp.set = true
+	}
+}
+
+// SetStore enables persistence of run history and checkpoints.
+func (f *Fetcher) SetStore(store StateStore) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.store = store
+}
+
+func (f *Fetcher) getStore() StateStore {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.store
+}
+
+// withCheckpoint loads the persisted checkpoint on the first run of the entry and puts it into ctx.
+func (f *Fetcher) withCheckpoint(ctx context.Context, e *entry) (context.Context, error) {
+	if store := f.getStore(); store != nil {
+		e.loadMu.Lock()
+		if !e.loaded {
+			checkpoint, err := store.LoadCheckpoint(ctx, e.name)
+			if err != nil {
+				e.loadMu.Unlock()
+				return ctx, err
+			}
+
+			e.mu.Lock()
+			e.state.Checkpoint = checkpoint
+			e.mu.Unlock()
+			e.loaded = true
+		}
+		e.loadMu.Unlock()
+	}
+
+	return context.WithValue(ctx, checkpointKey{}, &runCheckpoint{prev: e.getState().Checkpoint}), nil
+}
+
+// commitCheckpoint persists the checkpoint set by the handler during the run.
+func (f *Fetcher) commitCheckpoint(ctx context.Context, e *entry) error {
+	cp, ok := ctx.Value(checkpointKey{}).(*runCheckpoint)
+	if !ok || !cp.set {
+		return nil
+	}
+
+	if store := f.getStore(); store != nil {
+		if err := store.SaveCheckpoint(ctx, e.name, cp.next); err != nil {
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	e.state.Checkpoint = cp.next
+	e.mu.Unlock()
+
+	return nil
+}
+
+// saveRun adds the run to the history of the store. Runs skipped by an open circuit breaker are not saved,
+// they would flood the history of frequent handlers while the upstream is down.
+func (f *Fetcher) saveRun(ctx context.Context, e *entry, state RunState) {
+	store := f.getStore()
+	if store == nil || errors.Is(state.LastErr, ErrCircuitOpen) {
+		return
+	}
+
+	run := RunModel{
+		Handler:    e.name,
+		URL:        state.LastURL,
+		StartedAt:  state.LastRun,
+		DurationMs: state.LastDuration.Milliseconds(),
+	}
+	if state.LastErr != nil {
+		run.Error = state.LastErr.Error()
+	}
+
+	// An interrupted run is still saved to the history.
+	ctx, cancel := detach(ctx)
+	defer cancel()
+
+	if err := store.SaveRun(ctx, run); err != nil {
+		log.GetLogger().Warn(ctx, "[Fetcher] Save run", zap.String("handler", e.name), zap.Error(err))
+	}
+}
@@ -0,0 +1,86 @@
+//go:build unit
+// +build unit
+
+package fetcher
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/redrru/fantasy-dota/pkg/db"
+)
+
+// newDryRunDB builds the statements without a connection.
+func newDryRunDB(t *testing.T) *gorm.DB {
+	sqlDB, err := sql.Open("pgx", "host=localhost port=1 dbname=test")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	orm, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	assert.NoError(t, err)
+
+	return orm
+}
+
+func TestDBStorePrune(t *testing.T) {
+	orm := newDryRunDB(t)
+
+	var inserts, deletes int32
+	assert.NoError(t, orm.Callback().Create().After("gorm:create").Register("test:count", func(tx *gorm.DB) {
+		atomic.AddInt32(&inserts, 1)
+	}))
+	assert.NoError(t, orm.Callback().Delete().After("gorm:delete").Register("test:count", func(tx *gorm.DB) {
+		atomic.AddInt32(&deletes, 1)
+	}))
+
+	store := NewDBStore(&db.DB{Gorm: orm}, time.Hour)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, store.SaveRun(ctx, RunModel{Handler: "heroes", StartedAt: time.Now()}))
+	}
+	assert.NoError(t, store.SaveRun(ctx, RunModel{Handler: "matches", StartedAt: time.Now()}))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&inserts))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&deletes), "runs of a handler are pruned once per interval")
+
+	store.mu.Lock()
+	store.lastPrune["heroes"] = time.Now().Add(-historyPruneInterval)
+	store.mu.Unlock()
+
+	assert.NoError(t, store.SaveRun(ctx, RunModel{Handler: "heroes", StartedAt: time.Now()}))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&deletes))
+
+	assert.NoError(t, NewDBStore(&db.DB{Gorm: orm}, 0).SaveRun(ctx, RunModel{Handler: "heroes", StartedAt: time.Now()}))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&deletes), "runs are kept forever without retention")
+}
+
+func TestDBStoreLoadCheckpointPrimary(t *testing.T) {
+	orm := newDryRunDB(t)
+
+	var queries, primary int32
+	assert.NoError(t, orm.Callback().Query().Before("gorm:query").Register("test:primary", func(tx *gorm.DB) {
+		atomic.AddInt32(&queries, 1)
+		if db.IsForcePrimary(tx.Statement.Context) {
+			atomic.AddInt32(&primary, 1)
+		}
+	}))
+
+	_, err := NewDBStore(&db.DB{Gorm: orm}, 0).LoadCheckpoint(context.Background(), "heroes")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&queries))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&primary), "the checkpoint must be read from the primary")
+}
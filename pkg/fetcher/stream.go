@@ -0,0 +1,47 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+)
+
+// StreamingHandler receives the response body as a stream instead of a buffered slice,
+// gzip and deflate bodies are decoded transparently and Config.MaxBodySize is enforced while reading.
+// A Handler implementing HandleStream is passed the stream and its Handle is not called, see Stream.
+type StreamingHandler interface {
+	GetRefreshTime() time.Duration
+	GetURL() string
+	// HandleStream must read the body before returning, it is closed afterwards.
+	HandleStream(ctx context.Context, body io.Reader) error
+}
+
+type streamHandler struct {
+	StreamingHandler
+}
+
+// Stream adapts StreamingHandler to Handler.
+func Stream(handler StreamingHandler) Handler {
+	return streamHandler{StreamingHandler: handler}
+}
+
+// Handle is used only if the handler is called with an already buffered response.
+func (h streamHandler) Handle(ctx context.Context, response []byte) error {
+	return h.HandleStream(ctx, bytes.NewReader(response))
+}
+
+func (h streamHandler) unwrap() interface{} {
+	return h.StreamingHandler
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
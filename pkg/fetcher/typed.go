@@ -0,0 +1,133 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const snippetSize = 256
+
+// Validator may be implemented by a decoded value to reject payloads that are valid JSON but not usable.
+type Validator interface {
+	Validate() error
+}
+
+// DecodeError is returned when a response can not be decoded or does not pass validation.
+type DecodeError struct {
+	Err error
+	// Snippet is the part of the payload around the error.
+	Snippet string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode response: %v, payload: '%s'", e.Err, e.Snippet)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeJSON unmarshals the response into target and validates it if target implements Validator.
+// Errors are returned as DecodeError and recorded on the span from ctx together with the payload snippet.
+func DecodeJSON(ctx context.Context, response []byte, target interface{}) error {
+	var err error
+	offset := int64(0)
+
+	if err = json.Unmarshal(response, target); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			offset = syntaxErr.Offset
+		case errors.As(err, &typeErr):
+			offset = typeErr.Offset
+		}
+	} else if v, ok := target.(Validator); ok {
+		err = v.Validate()
+	}
+	if err == nil {
+		return nil
+	}
+
+	decodeErr := &DecodeError{Err: err, Snippet: snippet(response, offset)}
+	trace.SpanFromContext(ctx).RecordError(decodeErr, trace.WithAttributes(
+		attribute.Bool("fetcher.decode_error", true),
+		attribute.String("fetcher.payload_snippet", decodeErr.Snippet),
+	))
+
+	return decodeErr
+}
+
+// snippet cuts snippetSize bytes of the payload centered at offset.
+func snippet(payload []byte, offset int64) string {
+	start := int(offset) - snippetSize/2
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetSize
+	if end > len(payload) {
+		end = len(payload)
+	}
+	if start > end {
+		start = end
+	}
+
+	for start < end && !utf8.RuneStart(payload[start]) {
+		start++
+	}
+
+	return string(payload[start:end])
+}
+
+// TypedHandler is a Handler receiving a decoded value instead of raw bytes, see JSON.
+// It may implement the same optional interfaces as Handler.
+type TypedHandler interface {
+	GetRefreshTime() time.Duration
+	GetURL() string
+	// NewValue returns a pointer to a new value the response is decoded into.
+	NewValue() interface{}
+	// HandleValue receives the value returned by NewValue after decoding and validation.
+	HandleValue(ctx context.Context, value interface{}) error
+}
+
+type jsonHandler struct {
+	TypedHandler
+}
+
+// JSON adapts TypedHandler to Handler decoding responses with DecodeJSON.
+func JSON(handler TypedHandler) Handler {
+	return jsonHandler{TypedHandler: handler}
+}
+
+func (h jsonHandler) Handle(ctx context.Context, response []byte) error {
+	value := h.NewValue()
+	if err := DecodeJSON(ctx, response, value); err != nil {
+		return err
+	}
+
+	return h.HandleValue(ctx, value)
+}
+
+func (h jsonHandler) unwrap() interface{} {
+	return h.TypedHandler
+}
+
+// wrapper is implemented by adapters to expose optional interfaces of the wrapped handler.
+type wrapper interface {
+	unwrap() interface{}
+}
+
+// implementation returns the value to check optional handler interfaces on.
+func implementation(handler Handler) interface{} {
+	if w, ok := handler.(wrapper); ok {
+		return w.unwrap()
+	}
+	return handler
+}
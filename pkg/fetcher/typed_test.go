@@ -0,0 +1,139 @@
+//go:build unit
+// +build unit
+
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testHero struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func (h *testHero) Validate() error {
+	if h.Name == "" {
+		return errors.New("empty name")
+	}
+	return nil
+}
+
+func TestDecodeJSON(t *testing.T) {
+	type args struct {
+		response string
+	}
+	type want struct {
+		hero    testHero
+		err     bool
+		snippet string
+	}
+
+	testCases := []struct {
+		name string
+		args args
+		want want
+	}{
+		{
+			name: "Valid",
+			args: args{response: `{"id": 1, "name": "Axe"}`},
+			want: want{hero: testHero{ID: 1, Name: "Axe"}},
+		},
+		{
+			name: "SyntaxError",
+			args: args{response: `{"id": 1, "name": }`},
+			want: want{err: true, snippet: `{"id": 1, "name": }`},
+		},
+		{
+			name: "TypeError",
+			args: args{response: `{"id": "one", "name": "Axe"}`},
+			want: want{err: true, snippet: `{"id": "one", "name": "Axe"}`},
+		},
+		{
+			name: "ValidationError",
+			args: args{response: `{"id": 1}`},
+			want: want{err: true, snippet: `{"id": 1}`},
+		},
+		{
+			name: "LongPayload",
+			args: args{response: `[` + strings.Repeat(`{"id": 1},`, 100) + `x]`},
+			want: want{err: true, snippet: strings.Repeat(`{"id": 1},`, 100)[1000-snippetSize/2+1:] + `x]`},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var hero testHero
+			var target interface{} = &hero
+			if strings.HasPrefix(tc.args.response, "[") {
+				target = &[]testHero{}
+			}
+
+			err := DecodeJSON(context.Background(), []byte(tc.args.response), target)
+			if !tc.want.err {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.want.hero, hero)
+				return
+			}
+
+			var decodeErr *DecodeError
+			assert.ErrorAs(t, err, &decodeErr)
+			assert.Equal(t, tc.want.snippet, decodeErr.Snippet)
+		})
+	}
+}
+
+type typedTestHandler struct {
+	url    string
+	heroes chan []testHero
+}
+
+func (h *typedTestHandler) GetRefreshTime() time.Duration {
+	return time.Hour
+}
+
+func (h *typedTestHandler) GetURL() string {
+	return h.url
+}
+
+func (h *typedTestHandler) GetName() string {
+	return "heroes"
+}
+
+func (h *typedTestHandler) NewValue() interface{} {
+	return &[]testHero{}
+}
+
+func (h *typedTestHandler) HandleValue(ctx context.Context, value interface{}) error {
+	h.heroes <- *value.(*[]testHero)
+	return nil
+}
+
+func TestJSONHandler(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(`[{"id": 1, "name": "Axe"}]`))
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	handler := &typedTestHandler{url: ts.URL, heroes: make(chan []testHero, 1)}
+
+	f := NewFetcher(Config{})
+	f.RegisterHandlers(JSON(handler))
+	go f.Run(context.Background())
+	defer f.Close(context.Background())
+
+	assert.Equal(t, []testHero{{ID: 1, Name: "Axe"}}, <-handler.heroes)
+	assert.Equal(t, "heroes", f.Status()[0].Name, "optional interfaces of the typed handler must be used")
+}
@@ -0,0 +1,288 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Auth adds credentials to a request before it is sent. The request is already a copy
+// owned by the Authenticator, so Apply may modify it in place.
+type Auth interface {
+	Apply(req *http.Request)
+}
+
+// AuthFunc is a function implementing Auth.
+type AuthFunc func(req *http.Request)
+
+func (f AuthFunc) Apply(req *http.Request) {
+	f(req)
+}
+
+// secretAuth is implemented by the Auth constructors of this package, it reports where the credentials are passed.
+type secretAuth interface {
+	secrets() (params, headers []string)
+}
+
+type queryKey struct {
+	param, key string
+}
+
+// QueryKey passes key in the query parameter param, e.g. "key" of Steam Web API or "api_key" of OpenDota.
+func QueryKey(param, key string) Auth {
+	return queryKey{param: param, key: key}
+}
+
+func (a queryKey) Apply(req *http.Request) {
+	query := req.URL.Query()
+	query.Set(a.param, a.key)
+	req.URL.RawQuery = query.Encode()
+}
+
+func (a queryKey) secrets() ([]string, []string) {
+	return []string{a.param}, nil
+}
+
+type headerKey struct {
+	header, key string
+}
+
+// HeaderKey passes key in the header, e.g. X-API-Key.
+func HeaderKey(header, key string) Auth {
+	return headerKey{header: header, key: key}
+}
+
+func (a headerKey) Apply(req *http.Request) {
+	req.Header.Set(a.header, a.key)
+}
+
+func (a headerKey) secrets() ([]string, []string) {
+	return nil, []string{a.header}
+}
+
+// Bearer passes token in the Authorization header.
+func Bearer(token string) Auth {
+	return HeaderKey("Authorization", "Bearer "+token)
+}
+
+type keyPool struct {
+	auths []Auth
+	next  uint32
+}
+
+// KeyPool spreads requests across several keys in round robin, newAuth creates the Auth passing a key,
+// e.g. KeyPool(keys, func(key string) Auth { return QueryKey("key", key) }). The rate limiter
+// limits each key separately, so a pool of n keys gets n times the quota of a single key.
+func KeyPool(keys []string, newAuth func(key string) Auth) Auth {
+	pool := &keyPool{auths: make([]Auth, len(keys))}
+	for i, key := range keys {
+		pool.auths[i] = newAuth(key)
+	}
+
+	return pool
+}
+
+func (p *keyPool) Apply(req *http.Request) {
+	if len(p.auths) == 0 {
+		return
+	}
+	p.auths[(atomic.AddUint32(&p.next, 1)-1)%uint32(len(p.auths))].Apply(req)
+}
+
+func (p *keyPool) secrets() (params, headers []string) {
+	for _, auth := range p.auths {
+		if a, ok := auth.(secretAuth); ok {
+			authParams, authHeaders := a.secrets()
+			params = append(params, authParams...)
+			headers = append(headers, authHeaders...)
+		}
+	}
+	return params, headers
+}
+
+// AuthSecrets returns the query parameters and the headers that carry the credentials of auths, sorted and
+// without duplicates, e.g. to redact them in HTTP fixtures. Credentials of AuthFunc and other custom
+// implementations are not known.
+func AuthSecrets(auths map[string][]Auth) (params, headers []string) {
+	paramSet, headerSet := map[string]bool{}, map[string]bool{}
+	for _, hostAuths := range auths {
+		for _, auth := range hostAuths {
+			a, ok := auth.(secretAuth)
+			if !ok {
+				continue
+			}
+
+			authParams, authHeaders := a.secrets()
+			for _, param := range authParams {
+				paramSet[param] = true
+			}
+			for _, header := range authHeaders {
+				headerSet[http.CanonicalHeaderKey(header)] = true
+			}
+		}
+	}
+
+	return sortedKeys(paramSet), sortedKeys(headerSet)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// ParseAuth parses credentials by host in the form "host=kind:args;...,host=...", where kind is one of
+//
+//	query:<param>:<key>   - key in the query parameter
+//	header:<name>:<key>   - key in the header
+//	bearer:<token>        - token in the Authorization header
+//
+// Several keys or tokens separated by "|" form a KeyPool, e.g.
+// "api.steampowered.com=query:key:KEY1|KEY2,api.stratz.com=bearer:TOKEN".
+func ParseAuth(s string) (map[string][]Auth, error) {
+	auths := map[string][]Auth{}
+
+	for _, hostAuths := range strings.Split(s, ",") {
+		hostAuths = strings.TrimSpace(hostAuths)
+		if hostAuths == "" {
+			continue
+		}
+
+		split := strings.SplitN(hostAuths, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("auth for %q: expected host=credentials", hostPrefix(hostAuths))
+		}
+
+		for _, spec := range strings.Split(split[1], ";") {
+			auth, err := parseAuth(strings.TrimSpace(spec))
+			if err != nil {
+				// The spec holds the keys, only the host is safe to report.
+				return nil, fmt.Errorf("auth for %q: %w", split[0], err)
+			}
+			auths[split[0]] = append(auths[split[0]], auth)
+		}
+	}
+
+	return auths, nil
+}
+
+func parseAuth(spec string) (Auth, error) {
+	split := strings.SplitN(spec, ":", 2)
+	if len(split) != 2 {
+		return nil, fmt.Errorf("expected kind:args")
+	}
+
+	var (
+		kind    = split[0]
+		args    = split[1]
+		name    string
+		newAuth func(key string) Auth
+	)
+
+	switch kind {
+	case "query", "header":
+		nameKey := strings.SplitN(args, ":", 2)
+		if len(nameKey) != 2 || nameKey[0] == "" {
+			return nil, fmt.Errorf("expected %s:<name>:<key>", kind)
+		}
+		name, args = nameKey[0], nameKey[1]
+
+		if kind == "query" {
+			newAuth = func(key string) Auth { return QueryKey(name, key) }
+		} else {
+			newAuth = func(key string) Auth { return HeaderKey(name, key) }
+		}
+	case "bearer":
+		newAuth = Bearer
+	default:
+		return nil, fmt.Errorf("unknown kind %q", kind)
+	}
+
+	keys := strings.Split(args, "|")
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("empty %s key", kind)
+		}
+	}
+	if len(keys) == 1 {
+		return newAuth(keys[0]), nil
+	}
+
+	return KeyPool(keys, newAuth), nil
+}
+
+// hostPrefix cuts the credentials off a malformed entry so that they do not end up in the error.
+func hostPrefix(s string) string {
+	if i := strings.IndexAny(s, ":;"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// Authenticator adds credentials of the request host to the requests.
+type Authenticator struct {
+	auths map[string][]Auth
+}
+
+// NewAuthenticator creates an authenticator with credentials by host name, credentials of AnyHost apply to the other hosts.
+func NewAuthenticator(auths map[string][]Auth) *Authenticator {
+	return &Authenticator{auths: auths}
+}
+
+// RoundTripper applies the credentials to a copy of every request sent with next.
+func (a *Authenticator) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		auths := a.hostAuths(req)
+		if len(auths) == 0 {
+			return next.RoundTrip(req)
+		}
+
+		req = req.Clone(req.Context())
+		for _, auth := range auths {
+			auth.Apply(req)
+		}
+
+		return next.RoundTrip(req)
+	})
+}
+
+func (a *Authenticator) hostAuths(req *http.Request) []Auth {
+	for _, host := range []string{req.URL.Hostname(), req.URL.Host, AnyHost} {
+		if auths, ok := a.auths[host]; ok {
+			return auths
+		}
+	}
+	return nil
+}
+
+// redactURL hides the API keys in the query and the password of u, it is used to log request URLs.
+func redactURL(u *url.URL) string {
+	query := u.Query()
+
+	redacted := false
+	for _, param := range apiKeyParams {
+		if query.Get(param) != "" {
+			query.Set(param, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.Redacted()
+	}
+
+	redactedURL := *u
+	redactedURL.RawQuery = query.Encode()
+
+	return redactedURL.Redacted()
+}
@@ -0,0 +1,496 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/redrru/fantasy-dota/pkg/log"
+)
+
+const defaultMaxEntrySize = 10 << 20
+
+// Results of cache lookups reported in metrics and the X-Cache response header.
+const (
+	cacheHit         = "hit"
+	cacheMiss        = "miss"
+	cacheRevalidated = "revalidated"
+	cacheBypass      = "bypass"
+)
+
+// CacheEntry is a stored response.
+type CacheEntry struct {
+	// Response is the response dumped with httputil.DumpResponse.
+	Response []byte
+	// Vary holds the values of the request headers listed in the Vary header of the response.
+	Vary http.Header
+	// RequestTime and ResponseTime are used to calculate the age of the response.
+	RequestTime  time.Time
+	ResponseTime time.Time
+	// ExpiresAt is the time after which the storage may drop the entry.
+	ExpiresAt time.Time
+}
+
+// CacheStorage stores cached responses by key.
+type CacheStorage interface {
+	// Get returns false if there is no entry or it has expired.
+	Get(ctx context.Context, key string) (CacheEntry, bool, error)
+	Set(ctx context.Context, key string, entry CacheEntry) error
+	Delete(ctx context.Context, key string) error
+}
+
+type CacheConfig struct {
+	// TTL is the freshness lifetime of 200 responses without Cache-Control max-age or Expires,
+	// 0 means such responses are not cached.
+	TTL time.Duration
+	// MaxEntrySize is the largest body that is cached, 10 MiB if not set.
+	MaxEntrySize int64
+}
+
+// Cache is a private HTTP cache following RFC 7234. Fresh responses are served without a request,
+// stale responses with validators are revalidated with a conditional request.
+type Cache struct {
+	storage CacheStorage
+	config  CacheConfig
+}
+
+func NewCache(storage CacheStorage, config CacheConfig) *Cache {
+	if config.MaxEntrySize <= 0 {
+		config.MaxEntrySize = defaultMaxEntrySize
+	}
+
+	return &Cache{storage: storage, config: config}
+}
+
+// RoundTripper serves GET requests from the cache and sends the rest with next.
+func (c *Cache) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return c.roundTrip(req, next)
+	})
+}
+
+func (c *Cache) roundTrip(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+	ctx := req.Context()
+	key := cacheKey(req)
+
+	if req.Method != http.MethodGet {
+		res, err := next.RoundTrip(req)
+		// A successful unsafe request invalidates the cached response of its url.
+		if err == nil && req.Method != http.MethodHead && isSuccess(res.StatusCode) {
+			c.delete(ctx, key)
+		}
+		return res, err
+	}
+
+	reqControl := parseCacheControl(req.Header)
+	if reqControl.has("no-store") {
+		cacheRequestsTotal.WithLabelValues(req.URL.Host, cacheBypass).Inc()
+		return next.RoundTrip(req)
+	}
+
+	entry, cached := c.get(ctx, key, req)
+	if !cached {
+		return c.fetch(req, next, key, cacheMiss)
+	}
+
+	cachedRes, err := entry.response(req)
+	if err != nil {
+		log.GetLogger().Warn(ctx, "[HttpCache] Read cached response", zap.String("url", req.URL.String()), zap.Error(err))
+		return c.fetch(req, next, key, cacheMiss)
+	}
+
+	now := time.Now()
+	if c.usable(entry, cachedRes, reqControl, now) {
+		cacheRequestsTotal.WithLabelValues(req.URL.Host, cacheHit).Inc()
+		setCacheHeaders(cachedRes, entry, cacheHit, now)
+
+		if notModified(req, cachedRes) {
+			return notModifiedResponse(req, cachedRes), nil
+		}
+		return cachedRes, nil
+	}
+
+	// A conditional request of the caller is passed as is, its 304 is not ours to handle.
+	if isConditional(req) || !hasValidators(cachedRes) {
+		closeCached(cachedRes)
+		return c.fetch(req, next, key, cacheMiss)
+	}
+
+	return c.revalidate(req, next, key, entry, cachedRes)
+}
+
+// usable reports whether the cached response may be returned without contacting the server.
+func (c *Cache) usable(entry CacheEntry, res *http.Response, reqControl cacheControl, now time.Time) bool {
+	resControl := parseCacheControl(res.Header)
+	if reqControl.has("no-cache") || resControl.has("no-cache") {
+		return false
+	}
+
+	age := entry.age(res, now)
+	if maxAge, ok := reqControl.duration("max-age"); ok && age > maxAge {
+		return false
+	}
+
+	lifetime := c.lifetime(res)
+	if minFresh, ok := reqControl.duration("min-fresh"); ok {
+		lifetime -= minFresh
+	}
+	if age < lifetime {
+		return true
+	}
+
+	if resControl.has("must-revalidate") {
+		return false
+	}
+
+	// max-stale without a value accepts a response of any staleness.
+	value, ok := reqControl["max-stale"]
+	if !ok {
+		return false
+	}
+	if value == "" {
+		return true
+	}
+	maxStale, _ := reqControl.duration("max-stale")
+
+	return age-lifetime <= maxStale
+}
+
+// fetch sends the request and stores a cacheable response.
+func (c *Cache) fetch(req *http.Request, next http.RoundTripper, key, result string) (*http.Response, error) {
+	cacheRequestsTotal.WithLabelValues(req.URL.Host, result).Inc()
+
+	requestTime := time.Now()
+	res, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store(req, res, key, requestTime)
+
+	return res, nil
+}
+
+// revalidate asks the server whether the stale response is still valid.
+func (c *Cache) revalidate(req *http.Request, next http.RoundTripper, key string, entry CacheEntry, cachedRes *http.Response) (*http.Response, error) {
+	revalidation := conditionalRequest(req, responseValidators(cachedRes))
+
+	requestTime := time.Now()
+	res, err := next.RoundTrip(revalidation)
+	if err != nil {
+		closeCached(cachedRes)
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusNotModified {
+		closeCached(cachedRes)
+		cacheRequestsTotal.WithLabelValues(req.URL.Host, cacheMiss).Inc()
+		c.store(req, res, key, requestTime)
+		return res, nil
+	}
+	closeCached(res)
+
+	cacheRequestsTotal.WithLabelValues(req.URL.Host, cacheRevalidated).Inc()
+
+	// Headers of 304 update the stored response, RFC 7234 section 4.3.4.
+	for name, values := range res.Header {
+		cachedRes.Header[name] = values
+	}
+
+	now := time.Now()
+	entry.RequestTime = requestTime
+	entry.ResponseTime = now
+	c.store(req, cachedRes, key, requestTime)
+	setCacheHeaders(cachedRes, entry, cacheRevalidated, now)
+
+	return cachedRes, nil
+}
+
+// store saves the response if it is cacheable, the body is buffered and put back into res.
+func (c *Cache) store(req *http.Request, res *http.Response, key string, requestTime time.Time) {
+	ctx := req.Context()
+	if !c.cacheable(req, res) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, c.config.MaxEntrySize+1))
+	if err != nil {
+		res.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), errReader{err}), Closer: res.Body}
+		return
+	}
+	if int64(len(body)) > c.config.MaxEntrySize {
+		res.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), res.Body), Closer: res.Body}
+		return
+	}
+	if err = res.Body.Close(); err != nil {
+		log.GetLogger().Warn(ctx, "[HttpCache] Close body", zap.String("url", req.URL.String()), zap.Error(err))
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	stored := *res
+	stored.Body = ioutil.NopCloser(bytes.NewReader(body))
+	stored.ContentLength = int64(len(body))
+	stored.TransferEncoding = nil
+	stored.Header = res.Header.Clone()
+	stored.Header.Del("X-Cache")
+
+	dump, err := httputil.DumpResponse(&stored, true)
+	if err != nil {
+		log.GetLogger().Warn(ctx, "[HttpCache] Dump response", zap.String("url", req.URL.String()), zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	lifetime := c.lifetime(res)
+	expiresAt := now.Add(lifetime)
+	// Stale responses with validators are kept for one more lifetime to be revalidated.
+	if hasValidators(res) {
+		expiresAt = expiresAt.Add(lifetime)
+	}
+
+	entry := CacheEntry{
+		Response:     dump,
+		Vary:         varyHeaders(req, res),
+		RequestTime:  requestTime,
+		ResponseTime: now,
+		ExpiresAt:    expiresAt,
+	}
+
+	if err = c.storage.Set(ctx, key, entry); err != nil {
+		cacheStorageErrorsTotal.WithLabelValues("set").Inc()
+		log.GetLogger().Warn(ctx, "[HttpCache] Store response", zap.String("url", req.URL.String()), zap.Error(err))
+	}
+}
+
+// cacheable follows RFC 7234 section 3, only 200 responses get the default TTL.
+func (c *Cache) cacheable(req *http.Request, res *http.Response) bool {
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMultipleChoices, http.StatusMovedPermanently,
+		http.StatusNotFound, http.StatusGone:
+	default:
+		return false
+	}
+
+	if parseCacheControl(req.Header).has("no-store") || parseCacheControl(res.Header).has("no-store") {
+		return false
+	}
+	if strings.TrimSpace(res.Header.Get("Vary")) == "*" {
+		return false
+	}
+
+	return c.lifetime(res) > 0
+}
+
+// lifetime is the freshness lifetime of the response, RFC 7234 section 4.2.1.
+func (c *Cache) lifetime(res *http.Response) time.Duration {
+	if maxAge, ok := parseCacheControl(res.Header).duration("max-age"); ok {
+		return maxAge
+	}
+
+	if expires := res.Header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(res.Header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		return expiresAt.Sub(date)
+	}
+
+	if res.StatusCode == http.StatusOK {
+		return c.config.TTL
+	}
+
+	return 0
+}
+
+func (c *Cache) get(ctx context.Context, key string, req *http.Request) (CacheEntry, bool) {
+	entry, ok, err := c.storage.Get(ctx, key)
+	if err != nil {
+		cacheStorageErrorsTotal.WithLabelValues("get").Inc()
+		log.GetLogger().Warn(ctx, "[HttpCache] Get response", zap.String("url", req.URL.String()), zap.Error(err))
+		return entry, false
+	}
+	if !ok {
+		return entry, false
+	}
+
+	for name, values := range entry.Vary {
+		if strings.Join(req.Header.Values(name), ", ") != strings.Join(values, ", ") {
+			return entry, false
+		}
+	}
+
+	return entry, true
+}
+
+func (c *Cache) delete(ctx context.Context, key string) {
+	if err := c.storage.Delete(ctx, key); err != nil {
+		cacheStorageErrorsTotal.WithLabelValues("delete").Inc()
+		log.GetLogger().Warn(ctx, "[HttpCache] Delete response", zap.String("key", key), zap.Error(err))
+	}
+}
+
+func (e CacheEntry) response(req *http.Request) (*http.Response, error) {
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(e.Response)), req)
+}
+
+// age is the current age of the response, RFC 7234 section 4.2.3.
+func (e CacheEntry) age(res *http.Response, now time.Time) time.Duration {
+	var apparentAge time.Duration
+	if date, err := http.ParseTime(res.Header.Get("Date")); err == nil && e.ResponseTime.After(date) {
+		apparentAge = e.ResponseTime.Sub(date)
+	}
+
+	correctedAge := e.ResponseTime.Sub(e.RequestTime)
+	if age, err := strconv.Atoi(res.Header.Get("Age")); err == nil {
+		correctedAge += time.Duration(age) * time.Second
+	}
+
+	if apparentAge > correctedAge {
+		correctedAge = apparentAge
+	}
+
+	return correctedAge + now.Sub(e.ResponseTime)
+}
+
+func cacheKey(req *http.Request) string {
+	return req.URL.String()
+}
+
+// varyHeaders always includes Accept-Encoding, a response stored encoded for the stream API
+// must not be returned to a request relying on the transparent decompression of the transport.
+func varyHeaders(req *http.Request, res *http.Response) http.Header {
+	vary := http.Header{"Accept-Encoding": req.Header.Values("Accept-Encoding")}
+	for _, value := range res.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				vary[http.CanonicalHeaderKey(name)] = req.Header.Values(name)
+			}
+		}
+	}
+	return vary
+}
+
+func setCacheHeaders(res *http.Response, entry CacheEntry, result string, now time.Time) {
+	res.Header.Set("Age", strconv.Itoa(int(entry.age(res, now).Seconds())))
+	res.Header.Set("X-Cache", result)
+}
+
+func isConditional(req *http.Request) bool {
+	return req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+}
+
+func hasValidators(res *http.Response) bool {
+	return res.Header.Get("ETag") != "" || res.Header.Get("Last-Modified") != ""
+}
+
+// notModified evaluates the conditional headers of the request against the cached response, RFC 7232 section 6.
+func notModified(req *http.Request, res *http.Response) bool {
+	if res.StatusCode != http.StatusOK {
+		return false
+	}
+
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := strings.TrimPrefix(res.Header.Get("ETag"), "W/")
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			if tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/"); tag == "*" || (etag != "" && tag == etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(res.Header.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
+
+func notModifiedResponse(req *http.Request, res *http.Response) *http.Response {
+	closeCached(res)
+
+	return &http.Response{
+		Status:     "304 Not Modified",
+		StatusCode: http.StatusNotModified,
+		Proto:      res.Proto,
+		ProtoMajor: res.ProtoMajor,
+		ProtoMinor: res.ProtoMinor,
+		Header:     res.Header,
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+func closeCached(res *http.Response) {
+	_ = res.Body.Close()
+}
+
+// cacheControl holds Cache-Control directives with their lowercase names.
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			split := strings.SplitN(directive, "=", 2)
+			name := strings.ToLower(strings.TrimSpace(split[0]))
+			if len(split) == 2 {
+				cc[name] = strings.Trim(strings.TrimSpace(split[1]), `"`)
+			} else {
+				cc[name] = ""
+			}
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// duration returns a delta-seconds directive, a directive without a valid value is reported as 0.
+func (cc cacheControl) duration(name string) (time.Duration, bool) {
+	value, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, true
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
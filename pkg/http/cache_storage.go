@@ -0,0 +1,209 @@
+package http
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/redrru/fantasy-dota/pkg/db"
+	"github.com/redrru/fantasy-dota/pkg/tracing"
+)
+
+const (
+	defaultMaxEntries = 1000
+	pruneInterval     = time.Minute
+)
+
+type memoryItem struct {
+	key   string
+	entry CacheEntry
+}
+
+// MemoryCache is an in-memory CacheStorage evicting the least recently used entries.
+type MemoryCache struct {
+	maxEntries int
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// NewMemoryCache creates a storage of up to maxEntries responses, 1000 if not set.
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+func (c *MemoryCache) Get(_ context.Context, key string) (CacheEntry, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return CacheEntry{}, false, nil
+	}
+
+	item := el.Value.(*memoryItem)
+	if time.Now().After(item.entry.ExpiresAt) {
+		c.remove(el)
+		return CacheEntry{}, false, nil
+	}
+	c.ll.MoveToFront(el)
+
+	return item.entry, true, nil
+}
+
+func (c *MemoryCache) Set(_ context.Context, key string, entry CacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*memoryItem).entry = entry
+		c.ll.MoveToFront(el)
+		return nil
+	}
+
+	c.items[key] = c.ll.PushFront(&memoryItem{key: key, entry: entry})
+	for c.ll.Len() > c.maxEntries {
+		c.remove(c.ll.Back())
+	}
+
+	return nil
+}
+
+func (c *MemoryCache) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+
+	return nil
+}
+
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+func (c *MemoryCache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*memoryItem).key)
+}
+
+type CacheModel struct {
+	Key          string `gorm:"primaryKey"`
+	Response     []byte `gorm:"not null"`
+	Vary         []byte
+	RequestTime  time.Time `gorm:"not null"`
+	ResponseTime time.Time `gorm:"not null"`
+	ExpiresAt    time.Time `gorm:"not null;index"`
+}
+
+func (m *CacheModel) TableName() string {
+	return "http_cache"
+}
+
+// CacheModels returns the models of DBCache to be registered for migration.
+func CacheModels() []interface{} {
+	return []interface{}{CacheModel{}}
+}
+
+// DBCache is a Postgres backed CacheStorage shared by all instances of the application.
+type DBCache struct {
+	db        *db.DB
+	lastPrune int64
+}
+
+// NewDBCache creates Postgres backed CacheStorage, expired entries are deleted at most once a minute on Set.
+func NewDBCache(db *db.DB) *DBCache {
+	return &DBCache{db: db}
+}
+
+func (c *DBCache) Get(ctx context.Context, key string) (CacheEntry, bool, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "HttpCacheGet")
+	defer span.End()
+
+	// Revalidated responses are written to the primary, a lagging replica would return the stale ones.
+	var model CacheModel
+	err := c.db.Conn(db.ForcePrimary(ctx)).Where("key = ? AND expires_at > ?", key, time.Now()).Take(&model).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return CacheEntry{}, false, nil
+	}
+	if err != nil {
+		return CacheEntry{}, false, err
+	}
+
+	entry := CacheEntry{
+		Response:     model.Response,
+		RequestTime:  model.RequestTime,
+		ResponseTime: model.ResponseTime,
+		ExpiresAt:    model.ExpiresAt,
+	}
+	if len(model.Vary) > 0 {
+		if err = json.Unmarshal(model.Vary, &entry.Vary); err != nil {
+			return CacheEntry{}, false, err
+		}
+	}
+
+	return entry, true, nil
+}
+
+func (c *DBCache) Set(ctx context.Context, key string, entry CacheEntry) error {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "HttpCacheSet")
+	defer span.End()
+
+	vary, err := json.Marshal(entry.Vary)
+	if err != nil {
+		return err
+	}
+
+	model := CacheModel{
+		Key:          key,
+		Response:     entry.Response,
+		Vary:         vary,
+		RequestTime:  entry.RequestTime,
+		ResponseTime: entry.ResponseTime,
+		ExpiresAt:    entry.ExpiresAt,
+	}
+
+	if err = c.db.Conn(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&model).Error; err != nil {
+		return err
+	}
+
+	return c.prune(ctx)
+}
+
+func (c *DBCache) Delete(ctx context.Context, key string) error {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "HttpCacheDelete")
+	defer span.End()
+
+	return c.db.Conn(ctx).Where("key = ?", key).Delete(&CacheModel{}).Error
+}
+
+func (c *DBCache) prune(ctx context.Context) error {
+	now := time.Now()
+	last := atomic.LoadInt64(&c.lastPrune)
+	if now.Sub(time.Unix(0, last)) < pruneInterval || !atomic.CompareAndSwapInt64(&c.lastPrune, last, now.UnixNano()) {
+		return nil
+	}
+
+	return c.db.Conn(ctx).Where("expires_at <= ?", now).Delete(&CacheModel{}).Error
+}
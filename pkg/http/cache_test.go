@@ -0,0 +1,243 @@
+//go:build unit
+// +build unit
+
+package http
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/redrru/fantasy-dota/pkg/db"
+)
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(2)
+	fresh := CacheEntry{ExpiresAt: time.Now().Add(time.Hour)}
+
+	assert.NoError(t, c.Set(ctx, "a", fresh))
+	assert.NoError(t, c.Set(ctx, "b", fresh))
+
+	_, ok, err := c.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.NoError(t, c.Set(ctx, "c", fresh))
+	assert.Equal(t, 2, c.Len())
+
+	_, ok, _ = c.Get(ctx, "b")
+	assert.False(t, ok, "least recently used entry must be evicted")
+	_, ok, _ = c.Get(ctx, "a")
+	assert.True(t, ok)
+
+	assert.NoError(t, c.Set(ctx, "expired", CacheEntry{ExpiresAt: time.Now().Add(-time.Second)}))
+	_, ok, _ = c.Get(ctx, "expired")
+	assert.False(t, ok)
+
+	assert.NoError(t, c.Delete(ctx, "a"))
+	_, ok, _ = c.Get(ctx, "a")
+	assert.False(t, ok)
+}
+
+func TestDBCacheGetPrimary(t *testing.T) {
+	sqlDB, err := sql.Open("pgx", "host=localhost port=1 dbname=test")
+	assert.NoError(t, err)
+	defer sqlDB.Close()
+
+	// Dry run builds the statements without a connection.
+	orm, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	assert.NoError(t, err)
+
+	var queries, primary int32
+	assert.NoError(t, orm.Callback().Query().Before("gorm:query").Register("test:primary", func(tx *gorm.DB) {
+		atomic.AddInt32(&queries, 1)
+		if db.IsForcePrimary(tx.Statement.Context) {
+			atomic.AddInt32(&primary, 1)
+		}
+	}))
+
+	_, _, err = NewDBCache(&db.DB{Gorm: orm}).Get(context.Background(), "GET https://api.opendota.com/api/heroes")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&queries))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&primary), "cached responses must be read from the primary")
+}
+
+func TestCache(t *testing.T) {
+	type request struct {
+		method string
+		header http.Header
+	}
+	type want struct {
+		upstream int32
+		status   int
+		xCache   string
+	}
+
+	testCases := []struct {
+		name     string
+		ttl      time.Duration
+		header   http.Header
+		requests []request
+		want     want
+	}{
+		{
+			name:     "MaxAgeHit",
+			header:   http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []request{{}, {}},
+			want:     want{upstream: 1, status: http.StatusOK, xCache: cacheHit},
+		},
+		{
+			name:     "DefaultTTL",
+			ttl:      time.Minute,
+			requests: []request{{}, {}},
+			want:     want{upstream: 1, status: http.StatusOK, xCache: cacheHit},
+		},
+		{
+			name:     "NoFreshness",
+			requests: []request{{}, {}},
+			want:     want{upstream: 2, status: http.StatusOK},
+		},
+		{
+			name:     "ResponseNoStore",
+			ttl:      time.Minute,
+			header:   http.Header{"Cache-Control": {"no-store, max-age=60"}},
+			requests: []request{{}, {}},
+			want:     want{upstream: 2, status: http.StatusOK},
+		},
+		{
+			name:     "RequestNoStore",
+			header:   http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []request{{}, {header: http.Header{"Cache-Control": {"no-store"}}}},
+			want:     want{upstream: 2, status: http.StatusOK},
+		},
+		{
+			name:     "Revalidate",
+			header:   http.Header{"Cache-Control": {"max-age=60, no-cache"}, "Etag": {`"v1"`}},
+			requests: []request{{}, {}},
+			want:     want{upstream: 2, status: http.StatusOK, xCache: cacheRevalidated},
+		},
+		{
+			name:     "RequestNoCache",
+			header:   http.Header{"Cache-Control": {"max-age=60"}, "Etag": {`"v1"`}},
+			requests: []request{{}, {header: http.Header{"Cache-Control": {"no-cache"}}}},
+			want:     want{upstream: 2, status: http.StatusOK, xCache: cacheRevalidated},
+		},
+		{
+			name:     "ConditionalHit",
+			header:   http.Header{"Cache-Control": {"max-age=60"}, "Etag": {`"v1"`}},
+			requests: []request{{}, {header: http.Header{"If-None-Match": {`"v1"`}}}},
+			want:     want{upstream: 1, status: http.StatusNotModified, xCache: cacheHit},
+		},
+		{
+			name:     "VaryMismatch",
+			header:   http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"X-Lang"}},
+			requests: []request{{header: http.Header{"X-Lang": {"en"}}}, {header: http.Header{"X-Lang": {"ru"}}}},
+			want:     want{upstream: 2, status: http.StatusOK},
+		},
+		{
+			name:     "UnsafeInvalidates",
+			header:   http.Header{"Cache-Control": {"max-age=60"}},
+			requests: []request{{}, {method: http.MethodPost}, {}},
+			want:     want{upstream: 3, status: http.StatusOK},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var upstream int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&upstream, 1)
+				for name, values := range tc.header {
+					w.Header()[name] = values
+				}
+				if etag := tc.header.Get("ETag"); etag != "" && r.Header.Get("If-None-Match") == etag {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				_, _ = w.Write([]byte("body"))
+			}))
+			defer ts.Close()
+
+			client := &http.Client{Transport: NewCache(NewMemoryCache(0), CacheConfig{TTL: tc.ttl}).RoundTripper(http.DefaultTransport)}
+
+			var res *http.Response
+			for _, r := range tc.requests {
+				method := r.method
+				if method == "" {
+					method = http.MethodGet
+				}
+				req, err := http.NewRequestWithContext(context.Background(), method, ts.URL, nil)
+				assert.NoError(t, err)
+				for name, values := range r.header {
+					req.Header[name] = values
+				}
+
+				res, err = client.Do(req)
+				assert.NoError(t, err)
+
+				body, err := ioutil.ReadAll(res.Body)
+				assert.NoError(t, err)
+				assert.NoError(t, res.Body.Close())
+				if res.StatusCode == http.StatusOK {
+					assert.Equal(t, "body", string(body))
+				}
+			}
+
+			assert.Equal(t, tc.want.upstream, atomic.LoadInt32(&upstream))
+			assert.Equal(t, tc.want.status, res.StatusCode)
+			assert.Equal(t, tc.want.xCache, res.Header.Get("X-Cache"))
+		})
+	}
+}
+
+func TestHttpClientCache(t *testing.T) {
+	var upstream int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstream, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("heroes"))
+	}))
+	defer ts.Close()
+
+	client := NewClient(
+		WithCache(NewCache(NewMemoryCache(0), CacheConfig{})),
+		WithRateLimiter(NewRateLimiter(map[string][]RateLimit{AnyHost: {{Requests: 1, Period: time.Hour}}})),
+	)
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		resp, err := client.Get(ctx, ts.URL)
+		cancel()
+		assert.NoError(t, err, "cache hits must not wait for the rate limiter")
+		assert.Equal(t, "heroes", string(resp))
+	}
+
+	_, _, err := client.GetConditional(context.Background(), ts.URL, Validators{ETag: `"v1"`})
+	assert.ErrorIs(t, err, ErrNotModified)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&upstream))
+	assert.Equal(t, float64(3), testutil.ToFloat64(cacheRequestsTotal.WithLabelValues(ts.Listener.Addr().String(), cacheHit)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(cacheRequestsTotal.WithLabelValues(ts.Listener.Addr().String(), cacheMiss)))
+}
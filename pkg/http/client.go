@@ -2,61 +2,286 @@ package http
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptrace"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/httptrace/otelhttptrace"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
 	"github.com/redrru/fantasy-dota/pkg/log"
 	"github.com/redrru/fantasy-dota/pkg/tracing"
 )
 
+// maxErrorBodySize limits the body kept in StatusError.
+const maxErrorBodySize = 4 << 10
+
+// StatusError is returned by Client when the response status is not 2xx.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	// Body is truncated to the first 4 KiB.
+	Body []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("response status: '%v', body: '%s'", e.Status, string(e.Body))
+}
+
+// StatusCode returns the status code of the StatusError in the chain of err, 0 if there is none.
+func StatusCode(err error) int {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode
+	}
+	return 0
+}
+
+// ErrNotModified is returned by Client.GetConditional on 304 Not Modified response.
+var ErrNotModified = errors.New("not modified")
+
+// Validators are the cache validators of a response used to make conditional requests.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
 type Client struct {
-	client *http.Client
+	client    *http.Client
+	transport http.RoundTripper
+	limiter   *RateLimiter
+	auth      *Authenticator
+	cache     *Cache
+	userAgent string
 }
 
-func NewClient() *Client {
-	return &Client{
-		client: &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
+// ClientOption customizes a Client created by NewClient or NewClientFromConfig.
+type ClientOption func(c *Client)
+
+// WithTransport replaces http.DefaultTransport, the transport is still wrapped with tracing.
+func WithTransport(transport http.RoundTripper) ClientOption {
+	return func(c *Client) {
+		c.transport = transport
 	}
 }
 
-func (c *Client) Get(ctx context.Context, url string) ([]byte, error) {
-	ctx, span := tracing.DefaultTracer().Start(ctx, "HttpClient")
-	defer span.End()
+// WithRateLimiter throttles the requests of the client.
+func WithRateLimiter(limiter *RateLimiter) ClientOption {
+	return func(c *Client) {
+		c.limiter = limiter
+	}
+}
 
-	ctx = httptrace.WithClientTrace(ctx, otelhttptrace.NewClientTrace(ctx))
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// WithAuth adds credentials to the requests. They are applied after the cache lookup,
+// so responses are cached by the URL without keys and the cache is shared by the keys of a pool.
+func WithAuth(auth *Authenticator) ClientOption {
+	return func(c *Client) {
+		c.auth = auth
+	}
+}
+
+// WithCache serves GET requests from the cache when possible.
+func WithCache(cache *Cache) ClientOption {
+	return func(c *Client) {
+		c.cache = cache
+	}
+}
+
+// WithUserAgent sets User-Agent of the requests that do not set it themselves.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{transport: http.DefaultTransport}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	// Cache hits skip the rate limiter. Credentials are added inside the traced transport,
+	// so that they do not end up in the span attributes, and before the limiter which limits every key separately.
+	transport := c.transport
+	if c.limiter != nil {
+		transport = c.limiter.RoundTripper(transport)
+	}
+	if c.auth != nil {
+		transport = c.auth.RoundTripper(transport)
+	}
+	transport = otelhttp.NewTransport(transport)
+	if c.cache != nil {
+		transport = c.cache.RoundTripper(transport)
+	}
+	c.client = &http.Client{Transport: transport}
+
+	return c
+}
+
+func (c *Client) Get(ctx context.Context, url string, opts ...RequestOption) ([]byte, error) {
+	return c.request(ctx, http.MethodGet, url, opts...)
+}
+
+func (c *Client) Post(ctx context.Context, url string, opts ...RequestOption) ([]byte, error) {
+	return c.request(ctx, http.MethodPost, url, opts...)
+}
+
+func (c *Client) Put(ctx context.Context, url string, opts ...RequestOption) ([]byte, error) {
+	return c.request(ctx, http.MethodPut, url, opts...)
+}
+
+func (c *Client) Delete(ctx context.Context, url string, opts ...RequestOption) ([]byte, error) {
+	return c.request(ctx, http.MethodDelete, url, opts...)
+}
+
+func (c *Client) request(ctx context.Context, method, url string, opts ...RequestOption) ([]byte, error) {
+	req, err := NewRequest(ctx, method, url, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	log.GetLogger().Debug(ctx, "Sending GET request", zap.String("url", url))
-	res, err := c.client.Do(req)
-	defer func() {
-		if res == nil || res.Body == nil {
-			return
-		}
-		if err := res.Body.Close(); err != nil {
-			log.GetLogger().Warn(ctx, "Close http body", zap.String("url", url), zap.Error(err))
-		}
-	}()
+	return c.Do(req)
+}
+
+// Do sends the request and returns the response body, responses other than 2xx are returned as StatusError.
+func (c *Client) Do(req *http.Request) ([]byte, error) {
+	body, _, err := c.do(req)
+	return body, err
+}
+
+// GetConditional sends If-None-Match/If-Modified-Since built from validators and
+// returns ErrNotModified if the server answers 304. On 200 it returns the body
+// together with the validators of the new response.
+func (c *Client) GetConditional(ctx context.Context, url string, validators Validators) ([]byte, Validators, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return nil, validators, err
 	}
 
+	return c.DoConditional(req, validators)
+}
+
+// DoConditional is GetConditional for an arbitrary request.
+func (c *Client) DoConditional(req *http.Request, validators Validators) ([]byte, Validators, error) {
+	body, res, err := c.do(conditionalRequest(req, validators), http.StatusNotModified)
+	if err != nil {
+		return nil, validators, err
+	}
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, validators, ErrNotModified
+	}
+
+	return body, responseValidators(res), nil
+}
+
+// do sends the request and reads the whole body.
+func (c *Client) do(req *http.Request, allowed ...int) ([]byte, *http.Response, error) {
+	res, err := c.send(req, allowed...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closeBody(req.Context(), res)
+
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
+		return nil, nil, err
+	}
+
+	return body, res, nil
+}
+
+// send sends the request, responses other than 2xx and the allowed statuses are returned as StatusError.
+// The span of the request ends when the returned body is closed.
+func (c *Client) send(req *http.Request, allowed ...int) (*http.Response, error) {
+	ctx, span := tracing.DefaultTracer().Start(req.Context(), "HttpClient")
+
+	ctx = httptrace.WithClientTrace(ctx, otelhttptrace.NewClientTrace(ctx))
+	req = req.WithContext(ctx)
+	url := redactURL(req.URL)
+
+	if c.userAgent != "" && req.Header.Get("User-Agent") == "" {
+		req.Header = req.Header.Clone()
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	log.GetLogger().Debug(ctx, fmt.Sprintf("Sending %s request", req.Method), zap.String("url", url))
+	res, err := c.client.Do(req)
+	if err != nil {
+		span.End()
 		return nil, err
 	}
+	res.Body = &spanBody{ReadCloser: res.Body, span: span}
+
+	if !isSuccess(res.StatusCode) && !containsStatus(allowed, res.StatusCode) {
+		defer closeBody(ctx, res)
 
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("response status: '%v', body: '%s'", res.Status, string(body))
+		body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+		if err != nil {
+			return nil, err
+		}
+		return nil, &StatusError{StatusCode: res.StatusCode, Status: res.Status, Header: res.Header, Body: body}
 	}
 
-	return body, nil
+	return res, nil
+}
+
+type spanBody struct {
+	io.ReadCloser
+	span trace.Span
+}
+
+func (b *spanBody) Close() error {
+	defer b.span.End()
+	return b.ReadCloser.Close()
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func closeBody(ctx context.Context, res *http.Response) {
+	if err := res.Body.Close(); err != nil {
+		log.GetLogger().Warn(ctx, "Close http body", zap.String("url", res.Request.URL.String()), zap.Error(err))
+	}
+}
+
+func conditionalRequest(req *http.Request, validators Validators) *http.Request {
+	req = req.Clone(req.Context())
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	return req
+}
+
+func responseValidators(res *http.Response) Validators {
+	return Validators{
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+	}
+}
+
+func isSuccess(status int) bool {
+	return status >= http.StatusOK && status < http.StatusMultipleChoices
+}
+
+func containsStatus(statuses []int, status int) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
 }
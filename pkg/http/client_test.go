@@ -6,8 +6,10 @@ package http
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/brianvoe/gofakeit/v6"
@@ -21,7 +23,7 @@ func TestHttpClient(t *testing.T) {
 	}
 	type want struct {
 		result []byte
-		err    bool
+		status int
 	}
 
 	result := gofakeit.Word()
@@ -48,7 +50,7 @@ func TestHttpClient(t *testing.T) {
 			},
 			want: want{
 				result: nil,
-				err:    true,
+				status: http.StatusInternalServerError,
 			},
 		},
 	}
@@ -69,8 +71,10 @@ func TestHttpClient(t *testing.T) {
 
 			httpClient := NewClient()
 			resp, err := httpClient.Get(context.Background(), ts.URL)
-			if tc.want.err {
-				assert.Error(t, err)
+			if tc.want.status != 0 {
+				var statusErr *StatusError
+				assert.ErrorAs(t, err, &statusErr)
+				assert.Equal(t, tc.want.status, statusErr.StatusCode)
 			} else {
 				assert.NoError(t, err)
 			}
@@ -78,3 +82,138 @@ func TestHttpClient(t *testing.T) {
 		})
 	}
 }
+
+func TestHttpClientGetConditional(t *testing.T) {
+	const (
+		etag         = `"v1"`
+		lastModified = "Mon, 23 May 2022 10:11:33 GMT"
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	httpClient := NewClient()
+
+	_, validators, err := httpClient.GetConditional(context.Background(), ts.URL, Validators{})
+	assert.NoError(t, err)
+	assert.Equal(t, Validators{ETag: etag, LastModified: lastModified}, validators)
+
+	resp, _, err := httpClient.GetConditional(context.Background(), ts.URL, validators)
+	assert.ErrorIs(t, err, ErrNotModified)
+	assert.Nil(t, resp)
+}
+
+func TestHttpClientMethods(t *testing.T) {
+	type args struct {
+		do   func(c *Client, ctx context.Context, url string, opts ...RequestOption) ([]byte, error)
+		opts []RequestOption
+	}
+	type want struct {
+		method      string
+		query       string
+		header      string
+		contentType string
+		body        string
+	}
+
+	testCases := []struct {
+		name string
+		args args
+		want want
+	}{
+		{
+			name: "Get",
+			args: args{
+				do:   (*Client).Get,
+				opts: []RequestOption{WithQuery("limit", "10"), WithQuery("offset", "20"), WithHeader("X-Test", "get")},
+			},
+			want: want{method: http.MethodGet, query: "limit=10&offset=20&q=1", header: "get"},
+		},
+		{
+			name: "PostJSON",
+			args: args{
+				do:   (*Client).Post,
+				opts: []RequestOption{WithJSON(map[string]int{"match_id": 1})},
+			},
+			want: want{method: http.MethodPost, query: "q=1", contentType: "application/json", body: `{"match_id":1}`},
+		},
+		{
+			name: "PutBody",
+			args: args{
+				do:   (*Client).Put,
+				opts: []RequestOption{WithBody("text/plain", []byte("body")), WithHeader("X-Test", "put")},
+			},
+			want: want{method: http.MethodPut, query: "q=1", header: "put", contentType: "text/plain", body: "body"},
+		},
+		{
+			name: "Delete",
+			args: args{
+				do: (*Client).Delete,
+			},
+			want: want{method: http.MethodDelete, query: "q=1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				assert.NoError(t, err)
+
+				assert.Equal(t, tc.want.method, r.Method)
+				assert.Equal(t, tc.want.query, r.URL.RawQuery)
+				assert.Equal(t, tc.want.header, r.Header.Get("X-Test"))
+				assert.Equal(t, tc.want.contentType, r.Header.Get("Content-Type"))
+				assert.Equal(t, tc.want.body, string(body))
+
+				w.WriteHeader(http.StatusCreated)
+				_, err = fmt.Fprint(w, r.Method)
+				assert.NoError(t, err)
+			}))
+			defer ts.Close()
+
+			resp, err := tc.args.do(NewClient(), context.Background(), ts.URL+"?q=1", tc.args.opts...)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want.method, string(resp))
+		})
+	}
+}
+
+func TestHttpClientStatusError(t *testing.T) {
+	body := strings.Repeat("x", maxErrorBodySize+1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, err := fmt.Fprint(w, body)
+		assert.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	_, err := NewClient().Post(context.Background(), ts.URL, WithJSON(struct{}{}))
+
+	var statusErr *StatusError
+	assert.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusTooManyRequests, statusErr.StatusCode)
+	assert.Equal(t, "10", statusErr.Header.Get("Retry-After"))
+	assert.Equal(t, body[:maxErrorBodySize], string(statusErr.Body))
+
+	assert.Equal(t, http.StatusTooManyRequests, StatusCode(fmt.Errorf("wrapped: %w", err)))
+	assert.Equal(t, 0, StatusCode(context.Canceled))
+}
+
+func TestNewRequestInvalidJSON(t *testing.T) {
+	_, err := NewRequest(context.Background(), http.MethodPost, "http://localhost", WithJSON(make(chan int)))
+	assert.Error(t, err)
+}
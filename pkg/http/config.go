@@ -0,0 +1,113 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const defaultKeepAlive = 30 * time.Second
+
+// Config configures the transport of a Client, zero values keep the defaults of http.DefaultTransport.
+type Config struct {
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+
+	// ProxyURL overrides the proxy taken from HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+	ProxyURL string
+	// RootCAFiles are PEM files with certificates trusted in addition to the system roots.
+	RootCAFiles []string
+
+	UserAgent string
+}
+
+// NewClientFromConfig creates a Client with a transport built from config, opts are applied after it.
+func NewClientFromConfig(config Config, opts ...ClientOption) (*Client, error) {
+	transport, err := NewTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(append([]ClientOption{WithTransport(transport), WithUserAgent(config.UserAgent)}, opts...)...), nil
+}
+
+// NewTransport clones http.DefaultTransport and applies config to it.
+func NewTransport(config Config) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.DialTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   config.DialTimeout,
+			KeepAlive: defaultKeepAlive,
+		}).DialContext
+	}
+	if config.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = config.TLSHandshakeTimeout
+	}
+	if config.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = config.ResponseHeaderTimeout
+	}
+	if config.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = config.IdleConnTimeout
+	}
+	if config.MaxIdleConns > 0 {
+		transport.MaxIdleConns = config.MaxIdleConns
+	}
+	if config.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	}
+	if config.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = config.MaxConnsPerHost
+	}
+
+	if config.ProxyURL != "" {
+		proxy, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy url failed: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if len(config.RootCAFiles) > 0 {
+		pool, err := rootCAs(config.RootCAFiles)
+		if err != nil {
+			return nil, err
+		}
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	return transport, nil
+}
+
+func rootCAs(files []string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	for _, file := range files {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read root CA failed: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in root CA %s", file)
+		}
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,108 @@
+//go:build unit
+// +build unit
+
+package http
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTransport(t *testing.T) {
+	transport, err := NewTransport(Config{
+		DialTimeout:           time.Second,
+		TLSHandshakeTimeout:   2 * time.Second,
+		ResponseHeaderTimeout: 3 * time.Second,
+		IdleConnTimeout:       4 * time.Second,
+		MaxIdleConns:          5,
+		MaxIdleConnsPerHost:   6,
+		MaxConnsPerHost:       7,
+		ProxyURL:              "http://proxy:3128",
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2*time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 3*time.Second, transport.ResponseHeaderTimeout)
+	assert.Equal(t, 4*time.Second, transport.IdleConnTimeout)
+	assert.Equal(t, 5, transport.MaxIdleConns)
+	assert.Equal(t, 6, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 7, transport.MaxConnsPerHost)
+
+	proxy, err := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "api.opendota.com"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://proxy:3128", proxy.String())
+
+	defaults, err := NewTransport(Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, http.DefaultTransport.(*http.Transport).MaxIdleConns, defaults.MaxIdleConns)
+	if defaults.TLSClientConfig != nil {
+		assert.Nil(t, defaults.TLSClientConfig.RootCAs)
+	}
+}
+
+func TestNewTransportErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.pem")
+	assert.NoError(t, ioutil.WriteFile(invalid, []byte("not a certificate"), 0o600))
+
+	testCases := []struct {
+		name   string
+		config Config
+	}{
+		{
+			name:   "InvalidProxy",
+			config: Config{ProxyURL: "://proxy"},
+		},
+		{
+			name:   "MissingRootCA",
+			config: Config{RootCAFiles: []string{filepath.Join(dir, "missing.pem")}},
+		},
+		{
+			name:   "InvalidRootCA",
+			config: Config{RootCAFiles: []string{invalid}},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewClientFromConfig(tc.config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewClientFromConfig(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.UserAgent()))
+	}))
+	defer ts.Close()
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o600))
+
+	_, err := NewClient().Get(context.Background(), ts.URL)
+	assert.Error(t, err, "server certificate must not be trusted by default")
+
+	client, err := NewClientFromConfig(Config{RootCAFiles: []string{ca}, UserAgent: "fantasy-dota/test"})
+	assert.NoError(t, err)
+
+	resp, err := client.Get(context.Background(), ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "fantasy-dota/test", string(resp))
+
+	resp, err = client.Get(context.Background(), ts.URL, WithHeader("User-Agent", "custom"))
+	assert.NoError(t, err)
+	assert.Equal(t, "custom", string(resp))
+}
@@ -0,0 +1,22 @@
+package http
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "http_client"
+
+var (
+	cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_requests_total",
+		Help:      "Number of GET requests passed through the response cache by result: hit, miss, revalidated or bypass.",
+	}, []string{"host", "result"})
+
+	cacheStorageErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_storage_errors_total",
+		Help:      "Number of failed response cache storage operations.",
+	}, []string{"op"})
+)
@@ -0,0 +1,346 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AnyHost is the key of the rate limits applied to hosts without their own limits.
+const AnyHost = "*"
+
+// apiKeyParams are the query parameters and apiKeyHeaders the headers that carry an API key,
+// requests with different keys to the same host are limited separately.
+var (
+	apiKeyParams  = []string{"api_key", "key"}
+	apiKeyHeaders = []string{"X-API-Key", "Authorization"}
+)
+
+// ErrRateLimited is returned by RateLimiter.Wait if the request is not allowed before the context deadline.
+// The request was not sent, so it is not a failure of the upstream.
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimit allows Requests per Period with bursts of up to Burst requests (1 if not set).
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+func (l RateLimit) String() string {
+	return fmt.Sprintf("%d/%s:%d", l.Requests, l.Period, l.burst())
+}
+
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+func (l RateLimit) burst() int {
+	if l.Burst <= 0 {
+		return 1
+	}
+	return l.Burst
+}
+
+// ParseRateLimits parses limits by host in the form "host=requests/period[:burst];...,host=...",
+// e.g. "api.opendota.com=60/1m;2000/24h:2000,*=10/1s". A request has to satisfy every limit of its host.
+func ParseRateLimits(s string) (map[string][]RateLimit, error) {
+	limits := map[string][]RateLimit{}
+
+	for _, hostLimits := range strings.Split(s, ",") {
+		hostLimits = strings.TrimSpace(hostLimits)
+		if hostLimits == "" {
+			continue
+		}
+
+		split := strings.SplitN(hostLimits, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("rate limit %q: expected host=limits", hostLimits)
+		}
+
+		for _, spec := range strings.Split(split[1], ";") {
+			limit, err := parseRateLimit(strings.TrimSpace(spec))
+			if err != nil {
+				return nil, fmt.Errorf("rate limit %q: %w", hostLimits, err)
+			}
+			limits[split[0]] = append(limits[split[0]], limit)
+		}
+	}
+
+	return limits, nil
+}
+
+func parseRateLimit(spec string) (RateLimit, error) {
+	var limit RateLimit
+
+	if i := strings.Index(spec, ":"); i >= 0 {
+		burst, err := strconv.Atoi(spec[i+1:])
+		if err != nil || burst <= 0 {
+			return limit, fmt.Errorf("invalid burst in %q", spec)
+		}
+		limit.Burst = burst
+		spec = spec[:i]
+	}
+
+	split := strings.SplitN(spec, "/", 2)
+	if len(split) != 2 {
+		return limit, fmt.Errorf("expected requests/period, got %q", spec)
+	}
+
+	requests, err := strconv.Atoi(split[0])
+	if err != nil || requests <= 0 {
+		return limit, fmt.Errorf("invalid requests in %q", spec)
+	}
+	period, err := time.ParseDuration(split[1])
+	if err != nil || period <= 0 {
+		return limit, fmt.Errorf("invalid period in %q", spec)
+	}
+
+	limit.Requests = requests
+	limit.Period = period
+
+	return limit, nil
+}
+
+// RateLimiter throttles requests with token buckets per host and API key. Besides the configured
+// limits it respects the Retry-After and X-RateLimit-* headers of the responses.
+type RateLimiter struct {
+	limits map[string][]RateLimit
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewRateLimiter creates a limiter with limits by host name, limits of AnyHost apply to the other hosts.
+func NewRateLimiter(limits map[string][]RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limits:  limits,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// RoundTripper waits for the limits before every request sent with next.
+func (l *RateLimiter) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if err := l.Wait(req.Context(), req); err != nil {
+			return nil, err
+		}
+
+		res, err := next.RoundTrip(req)
+		if err == nil {
+			l.Update(req, res)
+		}
+
+		return res, err
+	})
+}
+
+// Wait blocks until the request is allowed by the limits of its host or ctx is done.
+// It fails with ErrRateLimited right away if the request would not be allowed before the deadline of ctx.
+func (l *RateLimiter) Wait(ctx context.Context, req *http.Request) error {
+	var waited time.Duration
+
+	for {
+		now := time.Now()
+		wait := l.take(req, now)
+		if wait <= 0 {
+			break
+		}
+		if deadline, ok := ctx.Deadline(); ok && now.Add(wait).After(deadline) {
+			trace.SpanFromContext(ctx).AddEvent("rate limit exceeds deadline", trace.WithAttributes(
+				attribute.String("host", req.URL.Host),
+				attribute.String("wait", wait.String()),
+			))
+			return fmt.Errorf("%w: %s needs to wait %s", ErrRateLimited, req.URL.Host, wait)
+		}
+		waited += wait
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if waited > 0 {
+		trace.SpanFromContext(ctx).AddEvent("rate limited", trace.WithAttributes(
+			attribute.String("host", req.URL.Host),
+			attribute.String("wait", waited.String()),
+		))
+	}
+
+	return nil
+}
+
+// Update blocks the bucket of the request until the time the server asked to wait for.
+func (l *RateLimiter) Update(req *http.Request, res *http.Response) {
+	now := time.Now()
+	until := retryAfter(res, now)
+	if reset := rateLimitReset(res.Header, now); reset.After(until) {
+		until = reset
+	}
+	if !until.After(now) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b := l.bucket(req, now); until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+}
+
+// take takes a token from every bucket of the request or returns how long to wait for them.
+func (l *RateLimiter) take(req *http.Request, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.bucket(req, now).take(now)
+}
+
+func (l *RateLimiter) bucket(req *http.Request, now time.Time) *bucket {
+	key := req.URL.Host
+	if apiKey := requestAPIKey(req); apiKey != "" {
+		key += "#" + apiKey
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newBucket(l.hostLimits(req), now)
+		l.buckets[key] = b
+	}
+
+	return b
+}
+
+func (l *RateLimiter) hostLimits(req *http.Request) []RateLimit {
+	for _, host := range []string{req.URL.Hostname(), req.URL.Host, AnyHost} {
+		if limits, ok := l.limits[host]; ok {
+			return limits
+		}
+	}
+	return nil
+}
+
+type bucket struct {
+	limits       []RateLimit
+	tokens       []float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+func newBucket(limits []RateLimit, now time.Time) *bucket {
+	b := &bucket{limits: limits, tokens: make([]float64, len(limits)), last: now}
+	for i, limit := range limits {
+		b.tokens[i] = float64(limit.burst())
+	}
+
+	return b
+}
+
+func (b *bucket) take(now time.Time) time.Duration {
+	if now.Before(b.blockedUntil) {
+		return b.blockedUntil.Sub(now)
+	}
+
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+
+	var wait time.Duration
+	for i, limit := range b.limits {
+		b.tokens[i] = math.Min(float64(limit.burst()), b.tokens[i]+elapsed*limit.rate())
+		if b.tokens[i] < 1 {
+			if w := time.Duration((1 - b.tokens[i]) / limit.rate() * float64(time.Second)); w > wait {
+				wait = w
+			}
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	for i := range b.tokens {
+		b.tokens[i]--
+	}
+
+	return 0
+}
+
+func requestAPIKey(req *http.Request) string {
+	query := req.URL.Query()
+	for _, param := range apiKeyParams {
+		if v := query.Get(param); v != "" {
+			return v
+		}
+	}
+	for _, header := range apiKeyHeaders {
+		if v := req.Header.Get(header); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// retryAfter parses Retry-After of 429 and 503 responses, both delay seconds and HTTP date are accepted.
+func retryAfter(res *http.Response, now time.Time) time.Time {
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable {
+		return time.Time{}
+	}
+
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return time.Time{}
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date
+	}
+
+	return time.Time{}
+}
+
+// rateLimitReset returns when an exhausted quota resets. X-RateLimit-Reset may hold either
+// seconds till the reset or a unix timestamp. OpenDota reports its minute and day quotas
+// in X-Rate-Limit-Remaining-Minute and X-Rate-Limit-Remaining-Day without a reset time.
+func rateLimitReset(header http.Header, now time.Time) time.Time {
+	var until time.Time
+
+	if header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil && reset > 0 {
+			if reset > 1e9 {
+				until = time.Unix(reset, 0)
+			} else {
+				until = now.Add(time.Duration(reset) * time.Second)
+			}
+		}
+	}
+
+	if header.Get("X-Rate-Limit-Remaining-Minute") == "0" {
+		if next := now.Truncate(time.Minute).Add(time.Minute); next.After(until) {
+			until = next
+		}
+	}
+
+	if header.Get("X-Rate-Limit-Remaining-Day") == "0" {
+		utc := now.UTC()
+		if next := time.Date(utc.Year(), utc.Month(), utc.Day()+1, 0, 0, 0, 0, time.UTC); next.After(until) {
+			until = next
+		}
+	}
+
+	return until
+}
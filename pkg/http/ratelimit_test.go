@@ -0,0 +1,195 @@
+//go:build unit
+// +build unit
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	type want struct {
+		limits map[string][]RateLimit
+		err    bool
+	}
+
+	testCases := []struct {
+		name string
+		args string
+		want want
+	}{
+		{
+			name: "Empty",
+			want: want{limits: map[string][]RateLimit{}},
+		},
+		{
+			name: "Hosts",
+			args: "api.opendota.com=60/1m;2000/24h:100, *=10/1s",
+			want: want{limits: map[string][]RateLimit{
+				"api.opendota.com": {
+					{Requests: 60, Period: time.Minute},
+					{Requests: 2000, Period: 24 * time.Hour, Burst: 100},
+				},
+				AnyHost: {{Requests: 10, Period: time.Second}},
+			}},
+		},
+		{
+			name: "NoHost",
+			args: "60/1m",
+			want: want{err: true},
+		},
+		{
+			name: "InvalidPeriod",
+			args: "api.opendota.com=60/minute",
+			want: want{err: true},
+		},
+		{
+			name: "InvalidBurst",
+			args: "api.opendota.com=60/1m:0",
+			want: want{err: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			limits, err := ParseRateLimits(tc.args)
+			if tc.want.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want.limits, limits)
+		})
+	}
+}
+
+func TestBucketTake(t *testing.T) {
+	now := time.Now()
+	b := newBucket([]RateLimit{{Requests: 1, Period: time.Second, Burst: 2}, {Requests: 10, Period: time.Hour, Burst: 10}}, now)
+
+	assert.Zero(t, b.take(now))
+	assert.Zero(t, b.take(now))
+	assert.Equal(t, time.Second, b.take(now))
+	assert.Zero(t, b.take(now.Add(time.Second)))
+
+	b.blockedUntil = now.Add(time.Minute)
+	assert.Equal(t, 59*time.Second, b.take(now.Add(time.Second)))
+}
+
+func TestRateLimitReset(t *testing.T) {
+	now := time.Date(2022, 5, 23, 10, 11, 33, 0, time.UTC)
+
+	testCases := []struct {
+		name   string
+		status int
+		header http.Header
+		want   time.Time
+	}{
+		{
+			name:   "RetryAfterSeconds",
+			status: http.StatusTooManyRequests,
+			header: http.Header{"Retry-After": {"30"}},
+			want:   now.Add(30 * time.Second),
+		},
+		{
+			name:   "RetryAfterDate",
+			status: http.StatusServiceUnavailable,
+			header: http.Header{"Retry-After": {"Mon, 23 May 2022 10:20:00 GMT"}},
+			want:   time.Date(2022, 5, 23, 10, 20, 0, 0, time.UTC),
+		},
+		{
+			name:   "RetryAfterIgnoredOnSuccess",
+			status: http.StatusOK,
+			header: http.Header{"Retry-After": {"30"}},
+		},
+		{
+			name:   "RemainingResetSeconds",
+			status: http.StatusOK,
+			header: http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {"15"}},
+			want:   now.Add(15 * time.Second),
+		},
+		{
+			name:   "RemainingResetTimestamp",
+			status: http.StatusOK,
+			header: http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {strconv.FormatInt(now.Add(time.Hour).Unix(), 10)}},
+			want:   now.Add(time.Hour),
+		},
+		{
+			name:   "QuotaLeft",
+			status: http.StatusOK,
+			header: http.Header{"X-Ratelimit-Remaining": {"5"}, "X-Ratelimit-Reset": {"15"}},
+		},
+		{
+			name:   "OpenDotaMinute",
+			status: http.StatusOK,
+			header: http.Header{"X-Rate-Limit-Remaining-Minute": {"0"}, "X-Rate-Limit-Remaining-Day": {"100"}},
+			want:   time.Date(2022, 5, 23, 10, 12, 0, 0, time.UTC),
+		},
+		{
+			name:   "OpenDotaDay",
+			status: http.StatusOK,
+			header: http.Header{"X-Rate-Limit-Remaining-Minute": {"0"}, "X-Rate-Limit-Remaining-Day": {"0"}},
+			want:   time.Date(2022, 5, 24, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			res := &http.Response{StatusCode: tc.status, Header: tc.header}
+
+			until := retryAfter(res, now)
+			if reset := rateLimitReset(res.Header, now); reset.After(until) {
+				until = reset
+			}
+			assert.True(t, tc.want.Equal(until), "want %s, got %s", tc.want, until)
+		})
+	}
+}
+
+func TestHttpClientRateLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api_key") == "throttled" {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient(WithRateLimiter(NewRateLimiter(map[string][]RateLimit{
+		AnyHost: {{Requests: 1, Period: 100 * time.Millisecond}},
+	})))
+
+	get := func(key string) time.Duration {
+		started := time.Now()
+		_, _ = client.Get(context.Background(), ts.URL+"?api_key="+key)
+		return time.Since(started)
+	}
+
+	assert.Less(t, int64(get("first")), int64(50*time.Millisecond))
+	assert.GreaterOrEqual(t, int64(get("first")), int64(50*time.Millisecond), "second request must wait for a token")
+	assert.Less(t, int64(get("second")), int64(50*time.Millisecond), "api keys are limited separately")
+
+	assert.Less(t, int64(get("throttled")), int64(50*time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := client.Get(ctx, ts.URL+"?api_key=throttled")
+	assert.ErrorIs(t, err, ErrRateLimited, "request must not be sent before Retry-After")
+	assert.Less(t, int64(time.Since(started)), int64(50*time.Millisecond), "wait past the deadline must fail fast")
+}
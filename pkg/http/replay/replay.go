@@ -0,0 +1,310 @@
+// Package replay records HTTP interactions into golden files under testdata and replays them in tests,
+// so that clients and fetchers can be tested against real upstream responses without network.
+//
+// Fixtures are replayed by default, set REPLAY_RECORD=1 to send real requests and rewrite them:
+//
+//	REPLAY_RECORD=1 go test -tags unit ./pkg/http/replay -run TestTransport -count=1
+package replay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	httpclient "github.com/redrru/fantasy-dota/pkg/http"
+)
+
+// RecordEnv is the environment variable switching the transports to record mode, e.g. REPLAY_RECORD=1.
+const RecordEnv = "REPLAY_RECORD"
+
+const redacted = "REDACTED"
+
+// Secrets are replaced in the recorded requests and responses, the same is done with the replayed requests before matching.
+// Credentials passed elsewhere are added with WithSecrets or WithAuth.
+var (
+	secretParams  = []string{"api_key", "key", "access_token", "token"}
+	secretHeaders = []string{"Authorization", "X-Api-Key", "Cookie", "Set-Cookie"}
+)
+
+// Option configures a Transport.
+type Option func(tr *Transport)
+
+// WithSecrets redacts the query parameters params and the headers in addition to the well-known ones.
+func WithSecrets(params, headers []string) Option {
+	return func(tr *Transport) {
+		tr.secretParams = append(tr.secretParams, params...)
+		tr.secretHeaders = append(tr.secretHeaders, headers...)
+	}
+}
+
+// WithAuth redacts the credentials of auths, e.g. parsed from HTTP_AUTH with http.ParseAuth, see http.AuthSecrets.
+func WithAuth(auths map[string][]httpclient.Auth) Option {
+	return WithSecrets(httpclient.AuthSecrets(auths))
+}
+
+// Interaction is a recorded request/response pair.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body is stored as a string if it is valid UTF-8 and as base64 otherwise, e.g. for gzip encoded responses.
+type Body []byte
+
+type encodedBody struct {
+	Base64 string `json:"base64"`
+}
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(encodedBody{Base64: base64.StdEncoding.EncodeToString(b)})
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+
+	var encoded encodedBody
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded.Base64)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+
+	return nil
+}
+
+// Transport replays the interactions of a golden file or records them.
+type Transport struct {
+	t      testing.TB
+	path   string
+	record bool
+	next   http.RoundTripper
+
+	secretParams  []string
+	secretHeaders []string
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewTransport creates a transport for the fixture testdata/fixtures/<name>.json of the package under test.
+// In record mode, see RecordEnv, requests are sent with http.DefaultTransport and the file is rewritten when the test ends.
+func NewTransport(t testing.TB, name string, opts ...Option) *Transport {
+	t.Helper()
+
+	record, _ := strconv.ParseBool(os.Getenv(RecordEnv))
+	tr := &Transport{
+		t:             t,
+		path:          filepath.Join("testdata", "fixtures", name+".json"),
+		record:        record,
+		next:          http.DefaultTransport,
+		secretParams:  append([]string(nil), secretParams...),
+		secretHeaders: append([]string(nil), secretHeaders...),
+	}
+	for _, opt := range opts {
+		opt(tr)
+	}
+
+	if tr.record {
+		t.Cleanup(tr.save)
+		return tr
+	}
+
+	data, err := ioutil.ReadFile(tr.path)
+	if err != nil {
+		t.Fatalf("replay: read fixture, run the test with %s=1 to create it: %v", RecordEnv, err)
+	}
+	if err = json.Unmarshal(data, &tr.interactions); err != nil {
+		t.Fatalf("replay: parse fixture %s: %v", tr.path, err)
+	}
+	tr.used = make([]bool, len(tr.interactions))
+
+	return tr
+}
+
+func (tr *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded, err := tr.newRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if tr.record {
+		return tr.send(req, recorded)
+	}
+
+	interaction, ok := tr.match(recorded)
+	if !ok {
+		return nil, fmt.Errorf("replay: no fixture for %s %s in %s, run the test with %s=1", recorded.Method, recorded.URL, tr.path, RecordEnv)
+	}
+
+	header := interaction.Response.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+		StatusCode:    interaction.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(interaction.Response.Body)),
+		ContentLength: int64(len(interaction.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// match returns the first unused interaction of the request, the last one is repeated once all are used.
+func (tr *Transport) match(req Request) (Interaction, bool) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	last := -1
+	for i, interaction := range tr.interactions {
+		if interaction.Request.Method != req.Method || interaction.Request.URL != req.URL || !bytes.Equal(interaction.Request.Body, req.Body) {
+			continue
+		}
+		if !tr.used[i] {
+			tr.used[i] = true
+			return interaction, true
+		}
+		last = i
+	}
+
+	if last < 0 {
+		return Interaction{}, false
+	}
+
+	return tr.interactions[last], true
+}
+
+func (tr *Transport) send(req *http.Request, recorded Request) (*http.Response, error) {
+	res, err := tr.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	tr.mu.Lock()
+	tr.interactions = append(tr.interactions, Interaction{
+		Request: recorded,
+		Response: Response{
+			StatusCode: res.StatusCode,
+			Header:     tr.redactHeader(res.Header),
+			Body:       body,
+		},
+	})
+	tr.mu.Unlock()
+
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return res, nil
+}
+
+func (tr *Transport) save() {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	data, err := json.MarshalIndent(tr.interactions, "", "  ")
+	if err != nil {
+		tr.t.Errorf("replay: encode fixture: %v", err)
+		return
+	}
+
+	if err = os.MkdirAll(filepath.Dir(tr.path), 0o755); err != nil {
+		tr.t.Errorf("replay: create fixture dir: %v", err)
+		return
+	}
+	if err = ioutil.WriteFile(tr.path, append(data, '\n'), 0o600); err != nil {
+		tr.t.Errorf("replay: write fixture: %v", err)
+	}
+}
+
+func (tr *Transport) newRequest(req *http.Request) (Request, error) {
+	recorded := Request{
+		Method: req.Method,
+		URL:    tr.redactURL(req.URL),
+		Header: tr.redactHeader(req.Header),
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return recorded, err
+		}
+		defer body.Close()
+
+		if recorded.Body, err = ioutil.ReadAll(body); err != nil {
+			return recorded, err
+		}
+	}
+
+	return recorded, nil
+}
+
+func (tr *Transport) redactURL(u *url.URL) string {
+	redactedURL := *u
+	query := redactedURL.Query()
+	for _, param := range tr.secretParams {
+		if query.Has(param) {
+			query.Set(param, redacted)
+		}
+	}
+	redactedURL.RawQuery = query.Encode()
+
+	return redactedURL.String()
+}
+
+func (tr *Transport) redactHeader(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+
+	redactedHeader := header.Clone()
+	for _, name := range tr.secretHeaders {
+		if redactedHeader.Get(name) != "" {
+			redactedHeader.Set(name, redacted)
+		}
+	}
+
+	return redactedHeader
+}
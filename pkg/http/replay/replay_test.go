@@ -0,0 +1,112 @@
+//go:build unit
+// +build unit
+
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/redrru/fantasy-dota/pkg/http"
+)
+
+func TestTransport(t *testing.T) {
+	client := http.NewClient(
+		http.WithTransport(NewTransport(t, "opendota_heroes")),
+		http.WithUserAgent("fantasy-dota/test"),
+	)
+	ctx := context.Background()
+
+	// The recorded api key is redacted, so any key matches the fixture.
+	res, err := client.Get(ctx, "https://api.opendota.com/api/heroes", http.WithQuery("api_key", "secret"))
+	assert.NoError(t, err)
+
+	var heroes []struct {
+		ID            int    `json:"id"`
+		LocalizedName string `json:"localized_name"`
+	}
+	assert.NoError(t, json.Unmarshal(res, &heroes))
+	assert.Len(t, heroes, 2)
+	assert.Equal(t, "Axe", heroes[1].LocalizedName)
+
+	// Interactions of the same request are replayed in order, the last one is repeated.
+	_, err = client.Post(ctx, "https://api.opendota.com/api/request/6227492909", http.WithJSON(map[string]int{"priority": 1}))
+	assert.Equal(t, 503, http.StatusCode(err))
+
+	for i := 0; i < 2; i++ {
+		res, err = client.Post(ctx, "https://api.opendota.com/api/request/6227492909", http.WithJSON(map[string]int{"priority": 1}))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0x1f, 0x8b}, res[:2])
+	}
+
+	_, err = client.Get(ctx, "https://api.opendota.com/api/matches")
+	assert.ErrorContains(t, err, RecordEnv)
+}
+
+func TestTransportRecordSecrets(t *testing.T) {
+	ts := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("Set-Cookie", "session=SECRET")
+		_, _ = w.Write([]byte(`{"result":{}}`))
+	}))
+	defer ts.Close()
+
+	auths, err := http.ParseAuth("*=query:steam_key:SECRET;header:X-Client-Token:SECRET|SECRET2")
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	t.Setenv(RecordEnv, "1")
+
+	t.Run("Record", func(t *testing.T) {
+		tr := NewTransport(t, "secrets", WithAuth(auths))
+		tr.path = filepath.Join(dir, "secrets.json")
+
+		client := http.NewClient(http.WithTransport(tr), http.WithAuth(http.NewAuthenticator(auths)))
+		_, err := client.Get(context.Background(), ts.URL+"/ISteamApps/GetAppList", http.WithQuery("format", "json"))
+		assert.NoError(t, err)
+	})
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "secrets.json"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "SECRET")
+	assert.Contains(t, string(data), "steam_key=REDACTED")
+
+	var interactions []Interaction
+	assert.NoError(t, json.Unmarshal(data, &interactions))
+	if assert.Len(t, interactions, 1) {
+		assert.Equal(t, []string{"REDACTED"}, interactions[0].Request.Header["X-Client-Token"])
+		assert.Equal(t, []string{"REDACTED"}, interactions[0].Response.Header["Set-Cookie"])
+	}
+}
+
+func TestBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body Body
+		json string
+	}{
+		{name: "text", body: Body(`{"id":1}`), json: `"{\"id\":1}"`},
+		{name: "binary", body: Body{0x1f, 0x8b, 0xff}, json: `{"base64":"H4v/"}`},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := json.Marshal(tc.body)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.json, string(data))
+
+			var body Body
+			assert.NoError(t, json.Unmarshal(data, &body))
+			assert.Equal(t, tc.body, body)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+type requestOptions struct {
+	header http.Header
+	query  url.Values
+	body   []byte
+	err    error
+}
+
+// RequestOption customizes a request built by NewRequest and the Client methods.
+type RequestOption func(o *requestOptions)
+
+// WithHeader adds a request header.
+func WithHeader(key, value string) RequestOption {
+	return func(o *requestOptions) {
+		o.header.Add(key, value)
+	}
+}
+
+// WithQuery adds a query parameter to the parameters already present in the url.
+func WithQuery(key, value string) RequestOption {
+	return func(o *requestOptions) {
+		o.query.Add(key, value)
+	}
+}
+
+// WithBody sets the request body and its Content-Type.
+func WithBody(contentType string, body []byte) RequestOption {
+	return func(o *requestOptions) {
+		o.header.Set("Content-Type", contentType)
+		o.body = body
+	}
+}
+
+// WithJSON sets the request body to v encoded as JSON.
+func WithJSON(v interface{}) RequestOption {
+	return func(o *requestOptions) {
+		body, err := json.Marshal(v)
+		if err != nil {
+			o.err = err
+			return
+		}
+		WithBody("application/json", body)(o)
+	}
+}
+
+// NewRequest builds a request with the options applied, it may be passed to Client.Do or returned from a fetcher RequestBuilder.
+func NewRequest(ctx context.Context, method, rawURL string, opts ...RequestOption) (*http.Request, error) {
+	o := &requestOptions{header: http.Header{}, query: url.Values{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.err != nil {
+		return nil, o.err
+	}
+
+	var body io.Reader
+	if o.body != nil {
+		body = bytes.NewReader(o.body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range o.header {
+		req.Header[key] = append(req.Header[key], values...)
+	}
+
+	if len(o.query) > 0 {
+		query := req.URL.Query()
+		for key, values := range o.query {
+			query[key] = append(query[key], values...)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
+	return req, nil
+}
@@ -0,0 +1,142 @@
+package http
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrBodyTooLarge is returned by a stream body when the decoded response exceeds the max body size.
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// Stream sends the request and returns the response body as a stream which must be closed by the caller.
+// gzip and deflate encoded bodies are decoded transparently, reading more than maxBodySize
+// decoded bytes fails with ErrBodyTooLarge (0 means no limit).
+func (c *Client) Stream(req *http.Request, maxBodySize int64) (io.ReadCloser, error) {
+	res, err := c.send(acceptEncoding(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeBody(res, maxBodySize)
+}
+
+// StreamConditional is Stream made conditional like DoConditional.
+func (c *Client) StreamConditional(req *http.Request, validators Validators, maxBodySize int64) (io.ReadCloser, Validators, error) {
+	res, err := c.send(acceptEncoding(conditionalRequest(req, validators)), http.StatusNotModified)
+	if err != nil {
+		return nil, validators, err
+	}
+
+	if res.StatusCode == http.StatusNotModified {
+		closeBody(req.Context(), res)
+		return nil, validators, ErrNotModified
+	}
+
+	body, err := decodeBody(res, maxBodySize)
+	if err != nil {
+		return nil, validators, err
+	}
+
+	return body, responseValidators(res), nil
+}
+
+func acceptEncoding(req *http.Request) *http.Request {
+	if req.Header.Get("Accept-Encoding") != "" {
+		return req
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+	return req
+}
+
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	var err error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if closeErr := b.closers[i].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func decodeBody(res *http.Response, maxBodySize int64) (io.ReadCloser, error) {
+	body := &decodedBody{Reader: res.Body, closers: []io.Closer{res.Body}}
+
+	switch strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(res.Body)
+		if err != nil {
+			closeBody(res.Request.Context(), res)
+			return nil, err
+		}
+		body.Reader = r
+		body.closers = append(body.closers, r)
+	case "deflate":
+		r, err := newDeflateReader(res.Body)
+		if err != nil {
+			closeBody(res.Request.Context(), res)
+			return nil, err
+		}
+		body.Reader = r
+		body.closers = append(body.closers, r)
+	}
+
+	if maxBodySize > 0 {
+		body.Reader = &limitedReader{r: body.Reader, max: maxBodySize}
+	}
+
+	return body, nil
+}
+
+// newDeflateReader accepts both zlib wrapped (as in RFC 7230) and raw deflate bodies sent by some servers.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+
+	header, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+
+	return flate.NewReader(buffered), nil
+}
+
+type limitedReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.read > l.max {
+		return 0, ErrBodyTooLarge
+	}
+
+	// Allow reading one byte over the limit to tell an exact size body from a larger one.
+	if rest := l.max - l.read + 1; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.max {
+		return n - 1, ErrBodyTooLarge
+	}
+
+	return n, err
+}
@@ -0,0 +1,120 @@
+//go:build unit
+// +build unit
+
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	case "deflate":
+		w, err = flate.NewWriter(&buf, flate.DefaultCompression)
+		assert.NoError(t, err)
+	default:
+		return data
+	}
+
+	_, err = w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func TestHttpClientStream(t *testing.T) {
+	type args struct {
+		encoding    string
+		header      string
+		maxBodySize int64
+	}
+	type want struct {
+		err error
+	}
+
+	result := []byte(gofakeit.Sentence(100))
+
+	testCases := []struct {
+		name string
+		args args
+		want want
+	}{
+		{
+			name: "Identity",
+		},
+		{
+			name: "Gzip",
+			args: args{encoding: "gzip", header: "gzip"},
+		},
+		{
+			name: "ZlibDeflate",
+			args: args{encoding: "zlib", header: "deflate"},
+		},
+		{
+			name: "RawDeflate",
+			args: args{encoding: "deflate", header: "deflate"},
+		},
+		{
+			name: "ExactMaxBodySize",
+			args: args{encoding: "gzip", header: "gzip", maxBodySize: int64(len(result))},
+		},
+		{
+			name: "TooLarge",
+			args: args{encoding: "gzip", header: "gzip", maxBodySize: int64(len(result)) - 1},
+			want: want{err: ErrBodyTooLarge},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "gzip, deflate", r.Header.Get("Accept-Encoding"))
+				if tc.args.header != "" {
+					w.Header().Set("Content-Encoding", tc.args.header)
+				}
+				_, err := w.Write(compress(t, tc.args.encoding, result))
+				assert.NoError(t, err)
+			}))
+			defer ts.Close()
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, ts.URL, nil)
+			assert.NoError(t, err)
+
+			body, err := NewClient().Stream(req, tc.args.maxBodySize)
+			assert.NoError(t, err)
+			defer body.Close()
+
+			resp, err := ioutil.ReadAll(body)
+			if tc.want.err != nil {
+				assert.ErrorIs(t, err, tc.want.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, result, resp)
+		})
+	}
+}
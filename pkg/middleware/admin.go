@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminAuthMiddleware requires "Authorization: Bearer <token>" for the routes under prefix, e.g. "/admin".
+// If token is empty the routes are disabled, so the admin API is never exposed without credentials.
+func AdminAuthMiddleware(prefix, token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// The route path is matched rather than the URL, which may be written in several ways.
+			if path := c.Path(); path != prefix && !strings.HasPrefix(path, prefix+"/") {
+				return next(c)
+			}
+
+			if token == "" {
+				return echo.NewHTTPError(http.StatusForbidden, "admin API is disabled")
+			}
+
+			auth := c.Request().Header.Get(echo.HeaderAuthorization)
+			bearer := strings.TrimPrefix(auth, "Bearer ")
+			if bearer == auth || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid admin token")
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+//go:build unit
+// +build unit
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	testCases := []struct {
+		name   string
+		token  string
+		path   string
+		header string
+		want   int
+	}{
+		{
+			name: "PublicRoute",
+			path: "/example",
+			want: http.StatusOK,
+		},
+		{
+			name:   "Disabled",
+			path:   "/admin/fetchers",
+			header: "Bearer secret",
+			want:   http.StatusForbidden,
+		},
+		{
+			name:  "MissingToken",
+			token: "secret",
+			path:  "/admin/fetchers",
+			want:  http.StatusUnauthorized,
+		},
+		{
+			name:   "WrongToken",
+			token:  "secret",
+			path:   "/admin/fetchers",
+			header: "Bearer wrong",
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "NotBearer",
+			token:  "secret",
+			path:   "/admin/fetchers",
+			header: "secret",
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "Authorized",
+			token:  "secret",
+			path:   "/admin/fetchers",
+			header: "Bearer secret",
+			want:   http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			e := echo.New()
+			e.Use(AdminAuthMiddleware("/admin", tc.token))
+			ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+			e.GET("/example", ok)
+			e.GET("/admin/fetchers", ok)
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, tc.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.want, rec.Code)
+		})
+	}
+}
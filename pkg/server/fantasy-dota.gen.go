@@ -4,9 +4,15 @@
 package server
 
 import (
+	"time"
+
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	AdminTokenScopes = "adminToken.Scopes"
+)
+
 // ExampleObject defines model for ExampleObject.
 type ExampleObject struct {
 	Name string `json:"name"`
@@ -15,14 +21,54 @@ type ExampleObject struct {
 // ExampleResponse defines model for ExampleResponse.
 type ExampleResponse = []ExampleObject
 
+// FetcherListResponse defines model for FetcherListResponse.
+type FetcherListResponse = []FetcherObject
+
+// FetcherObject defines model for FetcherObject.
+type FetcherObject struct {
+	// Duration of the last run in Go duration format.
+	LastDuration *string    `json:"last_duration,omitempty"`
+	LastError    *string    `json:"last_error,omitempty"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	Name         string     `json:"name"`
+	NextRun      *time.Time `json:"next_run,omitempty"`
+	Paused       bool       `json:"paused"`
+
+	// Refresh interval in Go duration format, 0s for scheduled fetchers.
+	RefreshTime string `json:"refresh_time"`
+	Runs        int    `json:"runs"`
+
+	// Schedule of the fetcher, e.g. cron expression.
+	Schedule *string `json:"schedule,omitempty"`
+	Url      string  `json:"url"`
+}
+
+// FetcherRunRequest defines model for FetcherRunRequest.
+type FetcherRunRequest struct {
+	Name string `json:"name"`
+}
+
+// PostAdminFetchersRunJSONBody defines parameters for PostAdminFetchersRun.
+type PostAdminFetchersRunJSONBody = FetcherRunRequest
+
 // PostExampleJSONBody defines parameters for PostExample.
 type PostExampleJSONBody = ExampleObject
 
+// PostAdminFetchersRunJSONRequestBody defines body for PostAdminFetchersRun for application/json ContentType.
+type PostAdminFetchersRunJSONRequestBody = PostAdminFetchersRunJSONBody
+
 // PostExampleJSONRequestBody defines body for PostExample for application/json ContentType.
 type PostExampleJSONRequestBody = PostExampleJSONBody
 
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
+	// List registered fetchers.
+	// (GET /admin/fetchers)
+	GetAdminFetchers(ctx echo.Context) error
+	// Run fetcher immediately.
+	// (POST /admin/fetchers/run)
+	PostAdminFetchersRun(ctx echo.Context) error
 	// Example GET handler.
 	// (GET /example)
 	GetExample(ctx echo.Context) error
@@ -36,6 +82,28 @@ type ServerInterfaceWrapper struct {
 	Handler ServerInterface
 }
 
+// GetAdminFetchers converts echo context to params.
+func (w *ServerInterfaceWrapper) GetAdminFetchers(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(AdminTokenScopes, []string{""})
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.GetAdminFetchers(ctx)
+	return err
+}
+
+// PostAdminFetchersRun converts echo context to params.
+func (w *ServerInterfaceWrapper) PostAdminFetchersRun(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(AdminTokenScopes, []string{""})
+
+	// Invoke the callback with all the unmarshalled arguments
+	err = w.Handler.PostAdminFetchersRun(ctx)
+	return err
+}
+
 // GetExample converts echo context to params.
 func (w *ServerInterfaceWrapper) GetExample(ctx echo.Context) error {
 	var err error
@@ -82,6 +150,8 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 		Handler: si,
 	}
 
+	router.GET(baseURL+"/admin/fetchers", wrapper.GetAdminFetchers)
+	router.POST(baseURL+"/admin/fetchers/run", wrapper.PostAdminFetchersRun)
 	router.GET(baseURL+"/example", wrapper.GetExample)
 	router.POST(baseURL+"/example", wrapper.PostExample)
 